@@ -0,0 +1,111 @@
+package network
+
+// Per-server bandwidth limits for the simulated network
+// Every server has an uplink and a downlink that transmit one message at a time, so a message
+// of size bytes occupies the sender's uplink and then the receiver's downlink for size/bandwidth
+// each. Messages queue behind earlier traffic on the same link, so delivery time depends on both
+// the encoded message size and concurrent traffic (i.e. a leader broadcasting 8 MB prepares)
+//
+// net.SetBandwidth(server, uplink, downlink) - Limit a server's bandwidth (in bits per second)
+// net.SetBandwidthAll(uplink, downlink)      - Limit the bandwidth of every server
+// => A bandwidth of 0 means unlimited (the default)
+// => Transmission delay is added to the link latency (see latency.go)
+//
+// net.SetBandwidth(1, 100*Mbps, 100*Mbps)
+
+import (
+	"time"
+)
+
+const (
+	Kbps int64 = 1000
+	Mbps int64 = 1000 * Kbps
+	Gbps int64 = 1000 * Mbps
+)
+
+type bandwidth struct {
+	uplink     int64     // Bits per second (0 = unlimited)
+	downlink   int64     // Bits per second (0 = unlimited)
+	uplinkFree time.Time // Time at which the uplink finishes transmitting its queued messages
+	downFree   time.Time // Time at which the downlink finishes receiving its queued messages
+}
+
+func (rn *Network) SetBandwidth(server int, uplink int64, downlink int64) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	bw := rn.getBandwidth(server)
+	bw.uplink = uplink
+	bw.downlink = downlink
+}
+
+func (rn *Network) SetBandwidthAll(uplink int64, downlink int64) {
+	rn.mu.Lock()
+	servers := []int{}
+	for servername, _ := range rn.servers {
+		if server, ok := servername.(int); ok {
+			servers = append(servers, server)
+		}
+	}
+	rn.mu.Unlock()
+
+	for _, server := range servers {
+		rn.SetBandwidth(server, uplink, downlink)
+	}
+}
+
+// Must hold rn.mu
+func (rn *Network) getBandwidth(server int) *bandwidth {
+	if _, ok := rn.bandwidths[server]; ok == false {
+		rn.bandwidths[server] = &bandwidth{}
+	}
+	return rn.bandwidths[server]
+}
+
+func transmitTime(size int, bps int64) time.Duration {
+	if bps <= 0 {
+		return 0
+	}
+	return time.Duration(int64(size) * 8 * int64(time.Second) / bps)
+}
+
+// Reserve the uplink of src and the downlink of dst for a message of size bytes and return how
+// long the message takes to get through both (including time spent queueing behind earlier
+// messages)
+func (rn *Network) transmitDelay(src interface{}, dst interface{}, size int) time.Duration {
+	srcId, ok1 := src.(int)
+	dstId, ok2 := dst.(int)
+	if ok1 == false || ok2 == false {
+		return 0
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	up, ok1 := rn.bandwidths[srcId]
+	down, ok2 := rn.bandwidths[dstId]
+	if ok1 == false && ok2 == false {
+		return 0
+	}
+
+	now := rn.clock.Now()
+	sent := now
+	if ok1 == true && up.uplink > 0 {
+		if up.uplinkFree.After(sent) {
+			sent = up.uplinkFree
+		}
+		sent = sent.Add(transmitTime(size, up.uplink))
+		up.uplinkFree = sent
+	}
+
+	received := sent
+	if ok2 == true && down.downlink > 0 {
+		if down.downFree.After(received) {
+			received = down.downFree
+		}
+		received = received.Add(transmitTime(size, down.downlink))
+		down.downFree = received
+	}
+
+	return received.Sub(now)
+}
@@ -0,0 +1,315 @@
+package network
+
+// Clocks and random sources for the simulated network
+// In simulation mode every random choice made by the network is derived from one seed and time
+// is virtual, so that a failing run can be rerun with the same network behaviour and many
+// simulated seconds run in milliseconds of real time
+//
+// net := MakeSimNetwork(seed) - Simulated network with seeded random choices and a virtual clock
+// net.Clock()                 - Clock driving the network's (and the protocols') timers
+// net.Seed()                  - Seed of the network's random choices
+// net.Cleanup()               - Stop the virtual clock
+//
+// The virtual clock advances automatically: once every goroutine in the process is blocked (none
+// running, runnable or in a system call), it jumps straight to the earliest pending timer and
+// fires it, then waits for the process to go idle again before firing the next one. A server
+// that is busy (i.e. signing) therefore never sees a timer fire early, and timers fire one at a
+// time in (deadline, creation) order. Protocol code must use Clock.After() and Clock.Sleep()
+// instead of the time package for any timer that should run on simulated time
+//
+// => While no timer is pending the clock blocks until one is set; while the process is busy it
+//    checks again every IDLEPOLL microseconds of real time (sleeping in between, so the clock
+//    doesn't take a core of its own)
+// => Idleness is read from the Go runtime's scheduler metrics, so it covers the whole process:
+//    anything else running (a real-time test, a leaked network) only slows the clock down
+// => Go toolchains without these metrics fall back to treating the process as idle once no
+//    goroutine has set a timer for SETTLE milliseconds of real time (so a busy server can see a
+//    timer fire early there)
+// => Each message draws its random choices (drops, delays, link loss, latency) from its own
+//    source, seeded by the network's seed, the message's link and how many messages were sent on
+//    that link before it (see makeMessageRand()), so the choices don't depend on the order in
+//    which messages on different links are processed
+// => What remains up to the Go runtime is the order in which goroutines woken by the same timer
+//    run (and so the order of concurrent messages on one link), select's choice between ready
+//    channels and map iteration order
+//
+// => The seed therefore reproduces the network's random choices, not the run itself: replaying a
+//    seed makes a failure likely to recur, but not certain, and two runs with the same seed may
+//    interleave (and so trace) differently. Making runs replay exactly would need a scheduler
+//    that runs one goroutine at a time, which Go doesn't offer
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+const SETTLE = 2    // Real time without clock activity before the fallback treats the process as idle (in milliseconds)
+const IDLEPOLL = 20 // Real time between checks while the process is busy (in microseconds)
+
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+var RealClock Clock = realClock{}
+
+type VirtualClock struct {
+	mu       sync.Mutex
+	now      time.Time
+	waiters  waiterHeap
+	seq      uint64           // Tie-breaker so that timers set for the same instant fire in FIFO order
+	samples  []metrics.Sample // Scheduler metrics read to tell whether the process is idle (nil if unsupported)
+	activity uint64           // Incremented whenever a goroutine sets a timer (for the fallback)
+	timerSet *sync.Cond       // Signalled when a timer is set or the clock is stopped
+	stopped  bool
+}
+
+type waiter struct {
+	at  time.Time
+	seq uint64
+	ch  chan time.Time
+}
+
+type waiterHeap []*waiter
+
+type lockedSource struct { // math/rand sources are not safe for concurrent use
+	mu  sync.Mutex
+	src rand.Source
+}
+
+type splitMix uint64 // Small and cheap to seed, for a source per message
+
+//
+// ------------------------------ REAL CLOCK ----------------------------------
+//
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.NewTimer(d).C
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+//
+// ----------------------------- VIRTUAL CLOCK --------------------------------
+//
+func MakeVirtualClock() *VirtualClock {
+	vc := &VirtualClock{}
+	vc.now = time.Unix(0, 0)
+	vc.waiters = waiterHeap{}
+	vc.samples = []metrics.Sample{
+		{Name: "/sched/goroutines/running:goroutines"},
+		{Name: "/sched/goroutines/runnable:goroutines"},
+		{Name: "/sched/goroutines/not-in-go:goroutines"},
+	}
+	metrics.Read(vc.samples)
+	for _, sample := range vc.samples {
+		if sample.Value.Kind() != metrics.KindUint64 { // i.e. KindBad on older Go toolchains
+			vc.samples = nil
+			break
+		}
+	}
+	vc.timerSet = sync.NewCond(&vc.mu)
+	vc.stopped = false
+
+	go vc.run()
+
+	return vc
+}
+
+func (vc *VirtualClock) Now() time.Time {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	return vc.now
+}
+
+func (vc *VirtualClock) After(d time.Duration) <-chan time.Time {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	vc.activity++
+	if d <= 0 {
+		ch <- vc.now
+		return ch
+	}
+
+	vc.seq++
+	heap.Push(&vc.waiters, &waiter{vc.now.Add(d), vc.seq, ch})
+	vc.timerSet.Signal()
+	return ch
+}
+
+func (vc *VirtualClock) Sleep(d time.Duration) {
+	<-vc.After(d)
+}
+
+// Move the clock forward by d, firing every timer that expires on the way
+func (vc *VirtualClock) Advance(d time.Duration) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	vc.advanceTo(vc.now.Add(d))
+}
+
+func (vc *VirtualClock) Stop() {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	vc.stopped = true
+	vc.timerSet.Signal()
+}
+
+func (vc *VirtualClock) run() {
+	for {
+		vc.mu.Lock()
+		for len(vc.waiters) == 0 && vc.stopped == false { // Nothing to fire until a timer is set
+			vc.timerSet.Wait()
+		}
+		if vc.stopped == true {
+			vc.mu.Unlock()
+			return
+		}
+		vc.mu.Unlock()
+
+		if vc.idle() == false {
+			time.Sleep(IDLEPOLL * time.Microsecond)
+			continue
+		}
+
+		vc.mu.Lock()
+		if len(vc.waiters) > 0 { // Nothing else can happen before the next timer, so jump to it
+			w := heap.Pop(&vc.waiters).(*waiter)
+			if w.at.After(vc.now) {
+				vc.now = w.at
+			}
+			w.ch <- vc.now
+		}
+		vc.mu.Unlock()
+	}
+}
+
+// Reports whether every goroutine other than the clock's own is blocked; checked twice, with a
+// chance for anything that was just woken to run in between
+func (vc *VirtualClock) idle() bool {
+	if vc.samples == nil {
+		return vc.settled()
+	}
+
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			runtime.Gosched()
+		}
+		metrics.Read(vc.samples)
+		running := vc.samples[0].Value.Uint64()
+		runnable := vc.samples[1].Value.Uint64()
+		syscalls := vc.samples[2].Value.Uint64()
+		if running > 1 || runnable > 0 || syscalls > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether no goroutine set a timer during a settle period (without scheduler metrics)
+func (vc *VirtualClock) settled() bool {
+	vc.mu.Lock()
+	last := vc.activity
+	vc.mu.Unlock()
+
+	time.Sleep(SETTLE * time.Millisecond)
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	return vc.activity == last
+}
+
+// Must hold vc.mu
+func (vc *VirtualClock) advanceTo(t time.Time) {
+	if t.After(vc.now) {
+		vc.now = t
+	}
+
+	for len(vc.waiters) > 0 && vc.waiters[0].at.After(vc.now) == false {
+		w := heap.Pop(&vc.waiters).(*waiter)
+		w.ch <- vc.now
+	}
+}
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h waiterHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *waiterHeap) Push(x interface{}) { *h = append(*h, x.(*waiter)) }
+
+func (h *waiterHeap) Pop() interface{} {
+	old := *h
+	w := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return w
+}
+
+// ----------------------------- RANDOM SOURCE --------------------------------
+//
+// Seeded random source that is safe for concurrent use (i.e. by interceptors)
+func MakeRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed)})
+}
+
+// Seeded random source for the choices made about the nth message sent on link l, so that they
+// don't depend on how many messages on other links drew from a shared source first
+func makeMessageRand(seed int64, l link, n int) *rand.Rand {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, seed)
+	binary.Write(h, binary.LittleEndian, int64(l.src))
+	binary.Write(h, binary.LittleEndian, int64(l.dst))
+	binary.Write(h, binary.LittleEndian, int64(n))
+
+	src := splitMix(h.Sum64())
+	return rand.New(&src)
+}
+
+func (ls *lockedSource) Int63() int64 {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.src.Int63()
+}
+
+func (ls *lockedSource) Seed(seed int64) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.src.Seed(seed)
+}
+
+func (s *splitMix) Int63() int64 {
+	*s += 0x9e3779b97f4a7c15
+	z := uint64(*s)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64((z ^ (z >> 31)) >> 1)
+}
+
+func (s *splitMix) Seed(seed int64) {
+	*s = splitMix(seed)
+}
@@ -1,30 +1,48 @@
 package network
 
 import (
+	"math/rand"
 	"reflect"
 	"sync"
+	"time"
 )
 
-const DEBUG = 1   // Debugging (0 = None, 1 = Info, 2 = Debug)
-const DELTA = 100 // Network time frame delta for XPaxos synchronous group (in milliseconds)
+const DEBUG = 1      // Debugging (0 = None, 1 = Info, 2 = Debug)
+const DELTA = 100    // Network time frame delta for XPaxos synchronous group (in milliseconds)
+const DEADPOLL = 100 // Default interval between checks for deleted servers (in milliseconds)
 
 type Network struct {
-	mu             sync.Mutex
-	reliable       bool
-	longDelays     bool                       // Pause a long time
-	longReordering bool                       // Reorder replies by occaisionally delaying them
-	ends           map[interface{}]*ClientEnd // Client endpoints by name
-	enabled        map[interface{}]bool
-	servers        map[interface{}]*Server     // Servers by name
-	connections    map[interface{}]interface{} // Map of endpoint name to server name
-	endCh          chan reqMsg
-	faultRate      map[interface{}]int
+	mu              sync.Mutex
+	seed            int64
+	sim             bool         // Simulation mode (see clock.go)
+	rand            *rand.Rand   // Random choices about messages outside simulation mode
+	messages        map[link]int // Requests sent so far on each link, to seed their random choices
+	clock           Clock        // All of the network's timers run on this clock
+	reliable        bool
+	deadPoll        time.Duration              // Interval between checks for deleted servers
+	longDelays      bool                       // Pause a long time
+	longReordering  bool                       // Reorder replies by occaisionally delaying them
+	ends            map[interface{}]*ClientEnd // Client endpoints by name
+	enabled         map[interface{}]bool
+	servers         map[interface{}]*Server     // Servers by name
+	connections     map[interface{}]interface{} // Map of endpoint name to server name
+	endCh           chan reqMsg
+	faultRate       map[interface{}]int
+	links           map[link]*linkState      // Per-link state by (src, dst) server names
+	partitions      map[string]map[link]bool // Links cut by each named partition
+	interceptors    map[int]*interceptor     // Message interceptors by ID
+	bandwidths      map[int]*bandwidth       // Per-server bandwidth limits (see bandwidth.go)
+	tracer          *tracer                  // Message trace being recorded, if any (see trace.go)
+	stats           Stats                    // Delivered messages by method and link (see stats.go)
+	errors          map[error]int            // Count of errors seen by client endpoints (see errors.go)
+	nextInterceptor int
 }
 
 type Server struct {
 	mu       sync.Mutex
 	services map[string]*Service
-	count    int // Count of incoming RPCs
+	count    int           // Count of incoming RPCs
+	errors   map[error]int // Count of rejected RPCs by kind of error (see errors.go)
 }
 
 type Service struct {
@@ -37,6 +55,7 @@ type Service struct {
 type ClientEnd struct {
 	endname interface{} // Client endpoint's name
 	ch      chan reqMsg // Copy of Network.endCh
+	net     *Network
 }
 
 type reqMsg struct {
@@ -46,9 +65,12 @@ type reqMsg struct {
 	args     []byte
 	replyCh  chan replyMsg
 	callerId int
+	call     *Call       // Asynchronous call completed by ProcessReq() itself (see transport.go)
+	reply    interface{} // Reply of the asynchronous call to decode into
 }
 
 type replyMsg struct {
 	ok    bool
 	reply []byte
+	err   error // Why the call failed, if the server rejected it (see errors.go)
 }
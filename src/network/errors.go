@@ -0,0 +1,93 @@
+package network
+
+// Errors returned by the network instead of killing the process
+// A malformed (or Byzantine) message must not take down a whole test, so unknown services,
+// unknown methods and undecodable arguments or replies fail the call with a typed error, are
+// counted, and leave the network running
+//
+// err := end.CallErr("XPaxos.Replicate", args, &reply, callerId) - Like Call() but returns an error
+// errors.Is(err, ErrUnknownMethod)                                - Test for a kind of error
+// net.ErrorCount(ErrDecode)                                       - Errors of a kind seen so far
+// => Calls lost by the network (drops, faults, deleted servers, timeouts) fail with ErrFailed,
+//    which is not counted
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrFailed         = errors.New("call failed")      // The network lost the request/reply or the server is down
+	ErrUnknownService = errors.New("unknown service")  // No service of that name on the server
+	ErrUnknownMethod  = errors.New("unknown method")   // No RPC handler of that name in the service
+	ErrDecode         = errors.New("decode failure")   // Arguments or reply could not be gob-decoded
+	ErrHandler        = errors.New("handler returned") // A net/rpc-style handler returned an error
+)
+
+type RPCError struct {
+	SvcMeth string
+	Err     error  // ErrUnknownService, ErrUnknownMethod, ErrDecode or ErrHandler
+	Detail  string // i.e. the services or methods that were expected
+}
+
+func (e *RPCError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s: %v", e.SvcMeth, e.Err)
+	}
+	return fmt.Sprintf("%s: %v (%s)", e.SvcMeth, e.Err, e.Detail)
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.Err
+}
+
+// Count an error against the server that handled (or rejected) the request
+func (rs *Server) countError(err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.errors[kindOf(err)]++
+}
+
+// Get a server's count of errors of the same kind as err
+func (rs *Server) ErrorCount(err error) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	return rs.errors[kindOf(err)]
+}
+
+// Count an error seen by a client endpoint (i.e. an undecodable reply)
+func (rn *Network) countError(err error) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.errors[kindOf(err)]++
+}
+
+// Get the number of errors of the same kind as err seen by the network's client endpoints and
+// servers
+func (rn *Network) ErrorCount(err error) int {
+	rn.mu.Lock()
+	count := rn.errors[kindOf(err)]
+	servers := []*Server{}
+	for _, server := range rn.servers {
+		if server != nil {
+			servers = append(servers, server)
+		}
+	}
+	rn.mu.Unlock()
+
+	for _, server := range servers {
+		count += server.ErrorCount(err)
+	}
+	return count
+}
+
+func kindOf(err error) error {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Err
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package network
+
+// Per-link latency distributions for WAN emulation
+// Every directed link (src, dst) can have its own one-way latency distribution; requests are
+// delayed by a sample from link (src, dst) and replies by a sample from link (dst, src)
+//
+// net.SetLinkLatency(src, dst, latency) - Set the latency distribution of the link src -> dst
+// net.LoadLatencyMatrix(path)           - Set the latency of every link from a matrix file
+//
+// latency := ConstantLatency{50 * time.Millisecond}
+// latency := NormalLatency{Mean: 50 * time.Millisecond, StdDev: 5 * time.Millisecond}
+// latency := ParetoLatency{Scale: 40 * time.Millisecond, Shape: 2.5}
+// latency := EmpiricalLatency{[]time.Duration{...}} - Uniformly resampled from measurements
+//
+// A latency matrix file has one row per source server and one column per destination server
+// (both starting at server 0, i.e. the client server), separated by whitespace. Entries are
+// written as ParseLatency() expects (in milliseconds) and lines starting with '#' are ignored:
+//
+// # client  xp1        xp2
+//   0       const:5    normal:80,5
+//   const:5 0          pareto:40,2.5
+//   80      empirical:78,80,95 0
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Latency interface {
+	Sample(r *rand.Rand) time.Duration
+}
+
+type ConstantLatency struct {
+	D time.Duration
+}
+
+type NormalLatency struct { // Truncated at zero
+	Mean   time.Duration
+	StdDev time.Duration
+}
+
+type ParetoLatency struct { // Heavy-tailed; Scale is the minimum latency
+	Scale time.Duration
+	Shape float64
+}
+
+type EmpiricalLatency struct {
+	Samples []time.Duration
+}
+
+func (l ConstantLatency) Sample(r *rand.Rand) time.Duration {
+	return l.D
+}
+
+func (l NormalLatency) Sample(r *rand.Rand) time.Duration {
+	d := time.Duration(r.NormFloat64()*float64(l.StdDev)) + l.Mean
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func (l ParetoLatency) Sample(r *rand.Rand) time.Duration {
+	u := 1 - r.Float64() // In (0, 1]
+	return time.Duration(float64(l.Scale) / math.Pow(u, 1/l.Shape))
+}
+
+func (l EmpiricalLatency) Sample(r *rand.Rand) time.Duration {
+	if len(l.Samples) == 0 {
+		return 0
+	}
+	return l.Samples[r.Intn(len(l.Samples))]
+}
+
+func (rn *Network) SetLinkLatency(src int, dst int, latency Latency) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.getLink(src, dst).latency = latency
+}
+
+func (rn *Network) LoadLatencyMatrix(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	matrix := [][]Latency{}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		row := []Latency{}
+		for _, spec := range strings.Fields(line) {
+			latency, err := ParseLatency(spec)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+			}
+			row = append(row, latency)
+		}
+		matrix = append(matrix, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for src, row := range matrix {
+		if len(row) != len(matrix) {
+			return fmt.Errorf("%s: latency matrix is not square", path)
+		}
+		for dst, latency := range row {
+			rn.SetLinkLatency(src, dst, latency)
+		}
+	}
+	return nil
+}
+
+// Parse a latency distribution (in milliseconds): "<ms>" or "const:<ms>", "normal:<mean>,<stddev>",
+// "pareto:<scale>,<shape>" or "empirical:<ms>,<ms>,..."
+func ParseLatency(spec string) (Latency, error) {
+	kind, params := "const", spec
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, params = spec[:i], spec[i+1:]
+	}
+
+	values := []float64{}
+	for _, param := range strings.Split(params, ",") {
+		value, err := strconv.ParseFloat(param, 64)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid latency %q", spec)
+		}
+		values = append(values, value)
+	}
+
+	ms := func(value float64) time.Duration {
+		return time.Duration(value * float64(time.Millisecond))
+	}
+
+	switch {
+	case kind == "const" && len(values) == 1:
+		return ConstantLatency{ms(values[0])}, nil
+	case kind == "normal" && len(values) == 2:
+		return NormalLatency{ms(values[0]), ms(values[1])}, nil
+	case kind == "pareto" && len(values) == 2 && values[1] > 0:
+		return ParetoLatency{ms(values[0]), values[1]}, nil
+	case kind == "empirical":
+		samples := []time.Duration{}
+		for _, value := range values {
+			samples = append(samples, ms(value))
+		}
+		return EmpiricalLatency{samples}, nil
+	}
+	return nil, fmt.Errorf("invalid latency %q", spec)
+}
+
+// Sample the one-way latency of the link between src and dst
+func (rn *Network) linkLatency(src interface{}, dst interface{}, r *rand.Rand) time.Duration {
+	srcId, ok1 := src.(int)
+	dstId, ok2 := dst.(int)
+	if ok1 == false || ok2 == false {
+		return 0
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if state, ok := rn.links[link{srcId, dstId}]; ok && state.latency != nil {
+		return state.latency.Sample(r)
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+package network
+
+// Per-link fault control for the simulated network
+// Links are directed: link (src, dst) carries requests from server src to server dst and
+// replies from dst back to src travel on link (dst, src). Servers are identified by the same
+// names passed to AddServer() and as callerId to Call() (i.e. XPaxos server IDs)
+//
+// net.SetLinkEnabled(src, dst, enabled) - Enable/disable the directed link src -> dst
+// net.SetLinkLoss(src, dst, rate)       - Drop rate% of messages on the link src -> dst
+// net.Partition(name, groups...)        - Cut every link between servers in different groups
+// => Servers that are not in any group keep all of their links (i.e. the client server)
+// net.Heal(name)                        - Remove a named partition
+// net.HealAll()                         - Remove all partitions, re-enable every link and reset
+//                                         every link's loss to 0 (latencies are kept)
+
+import "math/rand"
+
+type link struct {
+	src int
+	dst int
+}
+
+type linkState struct {
+	disabled bool
+	loss     int     // Percentage of messages dropped
+	latency  Latency // One-way latency distribution (see latency.go)
+}
+
+func (rn *Network) SetLinkEnabled(src int, dst int, enabled bool) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.getLink(src, dst).disabled = !enabled
+}
+
+func (rn *Network) SetLinkLoss(src int, dst int, rate int) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.getLink(src, dst).loss = rate
+}
+
+func (rn *Network) Partition(name string, groups ...[]int) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	cut := map[link]bool{}
+	for i, group1 := range groups {
+		for j, group2 := range groups {
+			if i != j {
+				for _, src := range group1 {
+					for _, dst := range group2 {
+						cut[link{src, dst}] = true
+					}
+				}
+			}
+		}
+	}
+	rn.partitions[name] = cut
+}
+
+func (rn *Network) Heal(name string) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	delete(rn.partitions, name)
+}
+
+func (rn *Network) HealAll() {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.partitions = map[string]map[link]bool{}
+	for _, state := range rn.links { // Link latencies are part of the topology, not faults
+		state.disabled = false
+		state.loss = 0
+	}
+}
+
+// Reports whether the link src -> dst is up (i.e. enabled and not cut by any partition)
+func (rn *Network) LinkUp(src int, dst int) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.linkUp(link{src, dst})
+}
+
+// Must hold rn.mu
+func (rn *Network) getLink(src int, dst int) *linkState {
+	l := link{src, dst}
+	if _, ok := rn.links[l]; ok == false {
+		rn.links[l] = &linkState{}
+	}
+	return rn.links[l]
+}
+
+// Must hold rn.mu
+func (rn *Network) linkUp(l link) bool {
+	if state, ok := rn.links[l]; ok && state.disabled == true {
+		return false
+	}
+	for _, cut := range rn.partitions {
+		if cut[l] == true {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether a message sent from src to dst is lost on the link between them
+func (rn *Network) linkDrops(src interface{}, dst interface{}, r *rand.Rand) bool {
+	srcId, ok1 := src.(int)
+	dstId, ok2 := dst.(int)
+	if ok1 == false || ok2 == false {
+		return false
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	l := link{srcId, dstId}
+	if rn.linkUp(l) == false {
+		return true
+	}
+	if state, ok := rn.links[l]; ok && (r.Int()%100) < state.loss {
+		return true
+	}
+	return false
+}
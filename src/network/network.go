@@ -8,14 +8,22 @@ package network
 // final project because XPaxos assumes a *strong* network model
 //
 // net := MakeNetwork()              - Holds network, clients, servers
+// net := MakeSimNetwork(seed)       - Seeded variant with a virtual clock (see clock.go)
 // end := net.MakeEnd(endname)       - Create a client endpoint to talk to one server
 // net.AddServer(servername, server) - Add a named server to network
 // net.DeleteServer(servername)      - Eliminate a named server from network
 // net.Connect(endname, servername)  - Connect a client to a server
 // net.Enable(endname, enabled)      - Enable/disable a client
 // net.Reliable(bool)                - False means drop/delay messages
+// net.Partition(name, groups...)    - Cut links between groups of servers (see links.go)
+// net.AddInterceptor(filter, fn)    - Inspect/modify/drop/delay messages (see intercept.go)
+// net.LoadLatencyMatrix(path)       - Per-link latency distributions (see latency.go)
+// net.SetBandwidth(server, up, down) - Per-server bandwidth limits (see bandwidth.go)
+// net.StartTrace(path)              - Record every request and reply to a file (see trace.go)
+// net.Stats()                       - Message counts and bytes by method and link (see stats.go)
+// net.MakeSchedule()                - Timeline of crashes, partitions, etc. (see schedule.go)
 //
-// end.Call("XPaxos.Replicate", args, &reply) - Send an RPC and wait for reply
+// end.Call("XPaxos.Replicate", args, &reply, callerId) - Send an RPC and wait for reply
 // => "XPaxos" is the name of the server struct to be called
 // => "Replicate" is the name of the method to be called
 // => Call() returns true to indicate that the server executed the request and the reply
@@ -26,8 +34,17 @@ package network
 //    may reorder messages
 // => Call() is guaranteed to return (perhaps after a delay) *except* if the handler function
 //    on the server-side does not return
+// => While a handler runs, the network checks every DEADPOLL ms whether its server has been
+//    deleted (see net.SetDeadPoll())
 // => The server RPC handler function must declare its reply arguments as pointers, so that
 //    their types exactly match the types of the arguments to Call()
+// end.CallWithTimeout("XPaxos.Replicate", args, &reply, callerId, timeout) - Per-RPC deadline
+// end.Go("XPaxos.Prepare", args, &reply, callerId, done)  - Asynchronous call (see transport.go)
+// end.CallErr("XPaxos.Replicate", args, &reply, callerId) - Like Call() but returns an error
+// => Unknown services/methods and undecodable messages fail the call (see errors.go)
+// end.Send("XPaxos.Ping", args, callerId)    - Send an RPC without waiting for the reply
+// end.Peer()                                 - Name of the server the endpoint is connected to
+// => ClientEnd (and TCPClientEnd) implement the Transport interface (see transport.go)
 //
 // srv := MakeServer() - Holds a collection of services all sharing the same RPC dispatcher
 // srv.AddService(svc) - A server can have multiple services (i.e. XPaxos and k/v)
@@ -40,6 +57,8 @@ package network
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"log"
 	"math/rand"
 	"reflect"
@@ -52,6 +71,58 @@ import (
 //
 func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}, callerId int) bool {
 	// The return value indicates success; false means the server couldn't be contacted
+	req := e.makeReq(svcMeth, args, callerId)
+	return e.deliver(req, reply) == nil
+}
+
+// Like Call() but returns why the call failed (see errors.go)
+func (e *ClientEnd) CallErr(svcMeth string, args interface{}, reply interface{}, callerId int) error {
+	req := e.makeReq(svcMeth, args, callerId)
+	return e.deliver(req, reply)
+}
+
+func (e *ClientEnd) CallWithTimeout(svcMeth string, args interface{}, reply interface{}, callerId int,
+	timeout time.Duration) bool {
+	call := e.Go(svcMeth, args, newReply(reply), callerId, nil)
+
+	select {
+	case <-call.Done:
+		if call.Ok == true {
+			copyReply(reply, call.Reply)
+		}
+		return call.Ok
+	case <-e.net.clock.After(timeout):
+		return false
+	}
+}
+
+// The goroutine that processes the request completes the call, so asynchronous calls don't cost
+// the caller a goroutine each
+func (e *ClientEnd) Go(svcMeth string, args interface{}, reply interface{}, callerId int, done chan *Call) *Call {
+	call := makeCall(svcMeth, args, reply, e.Peer(), done)
+	req := e.makeReq(svcMeth, args, callerId)
+	req.replyCh = make(chan replyMsg, 1) // Read by ProcessReq() once it has replied
+	req.call = call
+	req.reply = reply
+	e.ch <- req
+	return call
+}
+
+func (e *ClientEnd) Send(svcMeth string, args interface{}, callerId int) {
+	// Asynchronous send; the reply (if any) is discarded
+	req := e.makeReq(svcMeth, args, callerId)
+	req.replyCh = make(chan replyMsg, 1) // Nobody reads the reply
+	e.ch <- req
+}
+
+func (e *ClientEnd) Peer() interface{} {
+	e.net.mu.Lock()
+	defer e.net.mu.Unlock()
+
+	return e.net.connections[e.endname]
+}
+
+func (e *ClientEnd) makeReq(svcMeth string, args interface{}, callerId int) reqMsg {
 	req := reqMsg{}
 	req.endname = e.endname
 	req.svcMeth = svcMeth
@@ -64,33 +135,46 @@ func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}, ca
 	qe.Encode(args)
 	req.args = qb.Bytes()
 
-	e.ch <- req
+	return req
+}
 
-	rep := <-req.replyCh
-	if rep.ok {
-		rb := bytes.NewBuffer(rep.reply)
-		rd := gob.NewDecoder(rb)
-		if err := rd.Decode(reply); err != nil {
-			log.Fatalf("ClientEnd.Call(): decode reply: %v\n", err)
-		}
-		return true
-	} else {
-		return false
-	}
+func (e *ClientEnd) deliver(req reqMsg, reply interface{}) error {
+	e.ch <- req
+	return e.net.decodeReply(req, <-req.replyCh, reply)
 }
 
 //
 // ----------------------------- NETWORK FUNCTIONS ----------------------------
 //
 func MakeNetwork() *Network {
+	return makeNetwork(time.Now().UnixNano(), RealClock)
+}
+
+func MakeSimNetwork(seed int64) *Network {
+	return makeNetwork(seed, MakeVirtualClock())
+}
+
+func makeNetwork(seed int64, clock Clock) *Network {
 	rn := &Network{}
+	rn.seed = seed
+	rn.rand = MakeRand(seed)
+	rn.messages = map[link]int{}
+	rn.clock = clock
+	_, rn.sim = clock.(*VirtualClock)
 	rn.reliable = true
+	rn.deadPoll = DEADPOLL * time.Millisecond
 	rn.ends = map[interface{}]*ClientEnd{}
 	rn.enabled = map[interface{}]bool{}
 	rn.servers = map[interface{}]*Server{}
 	rn.connections = map[interface{}](interface{}){}
 	rn.endCh = make(chan reqMsg)
 	rn.faultRate = map[interface{}]int{}
+	rn.links = map[link]*linkState{}
+	rn.partitions = map[string]map[link]bool{}
+	rn.interceptors = map[int]*interceptor{}
+	rn.bandwidths = map[int]*bandwidth{}
+	rn.stats = Stats{}
+	rn.errors = map[error]int{}
 
 	go func() { // Single goroutine to handle all ClientEnd.Call()'s
 		for xreq := range rn.endCh {
@@ -101,10 +185,97 @@ func MakeNetwork() *Network {
 	return rn
 }
 
+func (rn *Network) Clock() Clock {
+	return rn.clock
+}
+
+func (rn *Network) Seed() int64 {
+	return rn.seed
+}
+
+// Stop the network's virtual clock (if any)
+func (rn *Network) Cleanup() {
+	if vc, ok := rn.clock.(*VirtualClock); ok {
+		vc.Stop()
+	}
+}
+
 func (rn *Network) SetFaultRate(server int, rate int) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
 	rn.faultRate[server] = rate
 }
 
+// Reports whether a message to or from server is lost because of the server's fault rate
+func (rn *Network) faultDrops(server interface{}, r *rand.Rand) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return (r.Int() % 100) < rn.faultRate[server]
+}
+
+// Random source for every choice the network makes about req; in simulation mode each request
+// gets its own source, seeded by its link and how many requests were sent on the link before (see
+// makeMessageRand())
+func (rn *Network) messageRand(req *reqMsg, servername interface{}) *rand.Rand {
+	if rn.sim == false {
+		return rn.rand
+	}
+
+	dst, ok := servername.(int)
+	if ok == false {
+		dst = -1
+	}
+	l := link{req.callerId, dst}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	n := rn.messages[l]
+	rn.messages[l]++
+	return makeMessageRand(rn.seed, l, n)
+}
+
+func (rn *Network) decodeReply(req reqMsg, rep replyMsg, reply interface{}) error {
+	if rep.ok {
+		if reply != nil {
+			rb := bytes.NewBuffer(rep.reply)
+			rd := gob.NewDecoder(rb)
+			if err := rd.Decode(reply); err != nil {
+				dPrintf("ClientEnd.Call(): decode reply: %v\n", err)
+				rn.countError(ErrDecode)
+				return &RPCError{req.svcMeth, ErrDecode, err.Error()}
+			}
+		}
+		return nil
+	} else if rep.err != nil {
+		return rep.err
+	} else {
+		return ErrFailed
+	}
+}
+
+// Complete an asynchronous call once ProcessReq() has replied to it
+func (rn *Network) complete(req reqMsg) {
+	req.call.done(rn.decodeReply(req, <-req.replyCh, req.reply))
+}
+
+// Set how often ProcessReq() checks whether a server has been deleted while a handler runs
+func (rn *Network) SetDeadPoll(d time.Duration) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.deadPoll = d
+}
+
+func (rn *Network) getDeadPoll() time.Duration {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.deadPoll
+}
+
 func (rn *Network) Reliable(yes bool) {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
@@ -152,26 +323,47 @@ func (rn *Network) IsServerDead(endname interface{}, servername interface{}, ser
 }
 
 func (rn *Network) ProcessReq(req reqMsg) {
+	if req.call != nil {
+		defer rn.complete(req)
+	}
+
 	enabled, servername, server, reliable, longreordering := rn.ReadEndnameInfo(req.endname)
+	r := rn.messageRand(&req, servername)
 
 	if enabled && servername != nil && server != nil {
 		if reliable == false {
-			ms := (rand.Int() % 27) // Artifically create a short random delay
-			time.Sleep(time.Duration(ms) * time.Millisecond)
+			ms := (r.Int() % 27) // Artifically create a short random delay
+			rn.clock.Sleep(time.Duration(ms) * time.Millisecond)
 		}
 
-		if reliable == false && (rand.Int()%1000) < 100 {
-			req.replyCh <- replyMsg{false, nil} // Drop the request and return as if timeout
+		if reliable == false && (r.Int()%1000) < 100 {
+			rn.recordReq(&req, servername, DROPPED)
+			req.replyCh <- replyMsg{false, nil, nil} // Drop the request and return as if timeout
 			return
 		}
 
-		if (rand.Int() % 100) < rn.faultRate[servername] { // Failure when sending to destination
+		if rn.faultDrops(servername, r) || rn.linkDrops(req.callerId, servername, r) { // Failure when sending to destination
 			dPrintf("Network: couldn't connect XPaxos server (%d) to XPaxos server (%d)\n", req.callerId, servername)
-			time.Sleep(time.Duration(DELTA) * time.Millisecond)
-			req.replyCh <- replyMsg{false, nil} // Drop the request and return as if timeout
+			rn.recordReq(&req, servername, DROPPED)
+			rn.clock.Sleep(time.Duration(DELTA) * time.Millisecond)
+			req.replyCh <- replyMsg{false, nil, nil} // Drop the request and return as if timeout
 			return
 		}
 
+		if m := rn.interceptRequest(&req, servername); m.Drop == true { // Adversary drops the request
+			rn.recordReq(&req, servername, DROPPED)
+			req.replyCh <- replyMsg{false, nil, nil}
+			return
+		} else {
+			rn.clock.Sleep(m.Delay + rn.linkLatency(req.callerId, servername, r) +
+				rn.transmitDelay(req.callerId, servername, len(req.args)))
+			for i := 0; i < m.Duplicates; i++ {
+				rn.recordReq(&req, servername, DELIVERED)
+				go server.dispatch(req)
+			}
+			rn.recordReq(&req, servername, DELIVERED)
+		}
+
 		// Execute the request in a separate thread so that we can periodically check if the server
 		// has been killed and the RPC should get a failure reply
 		ech := make(chan replyMsg)
@@ -188,14 +380,25 @@ func (rn *Network) ProcessReq(req reqMsg) {
 		for replyOK == false && serverDead == false {
 			select {
 			case reply = <-ech:
-				if (rand.Int() % 100) < rn.faultRate[req.callerId] { // Failure when sending to source
+				if rn.faultDrops(req.callerId, r) || rn.linkDrops(servername, req.callerId, r) { // Failure when sending to source
 					dPrintf("Network: couldn't connect XPaxos server (%d) to XPaxos server (%d)\n", servername, req.callerId)
-					time.Sleep(time.Duration(DELTA) * time.Millisecond)
-					req.replyCh <- replyMsg{false, nil} // Drop the request and return as if timeout
+					rn.recordReply(&req, servername, &reply, DROPPED)
+					rn.clock.Sleep(time.Duration(DELTA) * time.Millisecond)
+					req.replyCh <- replyMsg{false, nil, nil} // Drop the request and return as if timeout
 					return
 				}
+				if reply.ok == true {
+					if m := rn.interceptReply(&req, servername, &reply); m.Drop == true { // Adversary drops the reply
+						rn.recordReply(&req, servername, &reply, DROPPED)
+						req.replyCh <- replyMsg{false, nil, nil}
+						return
+					} else {
+						rn.clock.Sleep(m.Delay + rn.linkLatency(servername, req.callerId, r) +
+							rn.transmitDelay(servername, req.callerId, len(reply.reply)))
+					}
+				}
 				replyOK = true
-			case <-time.After(100 * time.Millisecond):
+			case <-rn.clock.After(rn.getDeadPoll()):
 				serverDead = rn.IsServerDead(req.endname, servername, server)
 			}
 		}
@@ -205,28 +408,48 @@ func (rn *Network) ProcessReq(req reqMsg) {
 		serverDead = rn.IsServerDead(req.endname, servername, server)
 
 		if replyOK == false || serverDead == true {
-			req.replyCh <- replyMsg{false, nil} // Server was killed while we were waiting; return error
-		} else if reliable == false && (rand.Int()%1000) < 100 {
-			req.replyCh <- replyMsg{false, nil} // Drop the reply and return as if timeout
-		} else if longreordering == true && rand.Intn(900) < 600 {
-			ms := 200 + rand.Intn(1+rand.Intn(2000)) // Artificially delay the response for a while
-			time.Sleep(time.Duration(ms) * time.Millisecond)
+			rn.recordReply(&req, servername, &reply, UNREACHABLE)
+			req.replyCh <- replyMsg{false, nil, nil} // Server was killed while we were waiting; return error
+		} else if reliable == false && (r.Int()%1000) < 100 {
+			rn.recordReply(&req, servername, &reply, DROPPED)
+			req.replyCh <- replyMsg{false, nil, nil} // Drop the reply and return as if timeout
+		} else if longreordering == true && r.Intn(900) < 600 {
+			ms := 200 + r.Intn(1+r.Intn(2000)) // Artificially delay the response for a while
+			rn.clock.Sleep(time.Duration(ms) * time.Millisecond)
+			rn.recordReply(&req, servername, &reply, DELIVERED)
 			req.replyCh <- reply
 		} else {
+			rn.recordReply(&req, servername, &reply, DELIVERED)
 			req.replyCh <- reply
 		}
 	} else { // Simulate no reply and an eventual timeout
+		rn.recordReq(&req, servername, UNREACHABLE)
 		ms := 0
 		if rn.longDelays {
-			ms = (rand.Int() % 7000)
+			ms = (r.Int() % 7000)
 		} else {
-			ms = (rand.Int() % 100)
+			ms = (r.Int() % 100)
 		}
-		time.Sleep(time.Duration(ms) * time.Millisecond)
-		req.replyCh <- replyMsg{false, nil}
+		rn.clock.Sleep(time.Duration(ms) * time.Millisecond)
+		req.replyCh <- replyMsg{false, nil, nil}
 	}
 }
 
+// Account for a request in the network's statistics and trace (see stats.go and trace.go)
+func (rn *Network) recordReq(req *reqMsg, servername interface{}, outcome string) {
+	if outcome == DELIVERED {
+		rn.count(req.svcMeth, req.callerId, servername, false, len(req.args))
+	}
+	rn.traceReq(req, servername, outcome)
+}
+
+func (rn *Network) recordReply(req *reqMsg, servername interface{}, reply *replyMsg, outcome string) {
+	if outcome == DELIVERED {
+		rn.count(req.svcMeth, servername, req.callerId, true, len(reply.reply))
+	}
+	rn.traceReply(req, servername, reply, outcome)
+}
+
 func (rn *Network) MakeEnd(endname interface{}) *ClientEnd {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
@@ -238,6 +461,7 @@ func (rn *Network) MakeEnd(endname interface{}) *ClientEnd {
 	e := &ClientEnd{}
 	e.endname = endname
 	e.ch = rn.endCh
+	e.net = rn
 	rn.ends[endname] = e
 	rn.enabled[endname] = false
 	rn.connections[endname] = nil
@@ -289,6 +513,7 @@ func (rn *Network) GetCount(servername interface{}) int {
 func MakeServer() *Server {
 	rs := &Server{}
 	rs.services = map[string]*Service{}
+	rs.errors = map[error]int{}
 	return rs
 }
 
@@ -303,6 +528,12 @@ func (rs *Server) dispatch(req reqMsg) replyMsg {
 	rs.count += 1
 
 	dot := strings.LastIndex(req.svcMeth, ".")
+	if dot < 0 {
+		rs.mu.Unlock()
+		err := &RPCError{req.svcMeth, ErrUnknownService, "expecting Service.Method"}
+		rs.countError(err)
+		return replyMsg{false, nil, err}
+	}
 	serviceName := req.svcMeth[:dot]
 	methodName := req.svcMeth[dot+1:]
 
@@ -311,15 +542,23 @@ func (rs *Server) dispatch(req reqMsg) replyMsg {
 	rs.mu.Unlock()
 
 	if ok {
-		return service.dispatch(methodName, req)
+		reply := service.dispatch(methodName, req)
+		if reply.err != nil && errors.Is(reply.err, ErrHandler) == false {
+			rs.countError(reply.err)
+		}
+		return reply
 	} else {
 		choices := []string{}
+		rs.mu.Lock()
 		for k, _ := range rs.services {
 			choices = append(choices, k)
 		}
-		log.Fatalf("labrpc.Server.dispatch(): unknown service %v in %v.%v; expecting one of %v\n",
+		rs.mu.Unlock()
+		dPrintf("labrpc.Server.dispatch(): unknown service %v in %v.%v; expecting one of %v\n",
 			serviceName, serviceName, methodName, choices)
-		return replyMsg{false, nil}
+		err := &RPCError{req.svcMeth, ErrUnknownService, fmt.Sprintf("expecting one of %v", choices)}
+		rs.countError(err)
+		return replyMsg{false, nil, err}
 	}
 }
 
@@ -332,6 +571,8 @@ func (rs *Server) GetCount() int {
 //
 // ----------------------------- SERVICE FUNCTIONS ----------------------------
 //
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
 func MakeService(rcvr interface{}) *Service { // A single server may have more than one Service
 	svc := &Service{}
 	svc.typ = reflect.TypeOf(rcvr)
@@ -347,8 +588,10 @@ func MakeService(rcvr interface{}) *Service { // A single server may have more t
 		//fmt.Printf("%v pp %v ni %v 1k %v 2k %v no %v\n",
 		//	mname, method.PkgPath, mtype.NumIn(), mtype.In(1).Kind(), mtype.In(2).Kind(), mtype.NumOut())
 
-		// The method is not suitable for an RPC handler
-		if method.PkgPath != "" || mtype.NumIn() != 3 || mtype.In(2).Kind() != reflect.Ptr || mtype.NumOut() != 0 {
+		// The method is not suitable for an RPC handler (net/rpc-style handlers returning an
+		// error are accepted too)
+		if method.PkgPath != "" || mtype.NumIn() != 3 || mtype.In(2).Kind() != reflect.Ptr ||
+			(mtype.NumOut() != 0 && (mtype.NumOut() != 1 || mtype.Out(0) != errorType)) {
 			//fmt.Printf("bad method: %v\n", mname)
 		} else {
 			svc.methods[mname] = method
@@ -360,12 +603,18 @@ func MakeService(rcvr interface{}) *Service { // A single server may have more t
 
 func (svc *Service) dispatch(methname string, req reqMsg) replyMsg {
 	if method, ok := svc.methods[methname]; ok { // Prepare space into which to read the argument
-		args := reflect.New(req.argsType) // The value's type will be a pointer to req.argsType
+		// Decode into the handler's argument type (not the caller's), so that arguments of the
+		// wrong type fail to decode instead of crashing the handler call
+		argsType := method.Type.In(1)
+		args := reflect.New(argsType) // The value's type will be a pointer to argsType
 
 		// (1) Decode the argument
 		ab := bytes.NewBuffer(req.args)
 		ad := gob.NewDecoder(ab)
-		ad.Decode(args.Interface())
+		if err := ad.Decode(args.Interface()); err != nil { // Malformed (or Byzantine) arguments
+			dPrintf("labrpc.Service.dispatch(): decode args of %v: %v\n", req.svcMeth, err)
+			return replyMsg{false, nil, &RPCError{req.svcMeth, ErrDecode, err.Error()}}
+		}
 
 		// (2) Allocate space for the reply
 		replyType := method.Type.In(2)
@@ -374,21 +623,24 @@ func (svc *Service) dispatch(methname string, req reqMsg) replyMsg {
 
 		// (3) Call the method
 		function := method.Func
-		function.Call([]reflect.Value{svc.rcvr, args.Elem(), replyv})
+		rv := function.Call([]reflect.Value{svc.rcvr, args.Elem(), replyv})
+		if len(rv) == 1 && rv[0].IsNil() == false { // net/rpc-style handler returned an error
+			return replyMsg{false, nil, &RPCError{req.svcMeth, ErrHandler, rv[0].Interface().(error).Error()}}
+		}
 
 		// (4) Encode the reply
 		rb := new(bytes.Buffer)
 		re := gob.NewEncoder(rb)
 		re.EncodeValue(replyv)
 
-		return replyMsg{true, rb.Bytes()}
+		return replyMsg{true, rb.Bytes(), nil}
 	} else {
 		choices := []string{}
 		for k, _ := range svc.methods {
 			choices = append(choices, k)
 		}
-		log.Fatalf("labrpc.Service.dispatch(): unknown method %v in %v; expecting one of %v\n",
+		dPrintf("labrpc.Service.dispatch(): unknown method %v in %v; expecting one of %v\n",
 			methname, req.svcMeth, choices)
-		return replyMsg{false, nil}
+		return replyMsg{false, nil, &RPCError{req.svcMeth, ErrUnknownMethod, fmt.Sprintf("expecting one of %v", choices)}}
 	}
 }
@@ -0,0 +1,376 @@
+package network
+
+// Timeline-based fault schedules for the simulated network
+// A schedule is a list of fault events, each triggered either at a time after the schedule
+// starts (on the network's clock) or once the workload has completed a number of operations,
+// so tests and benchmarks can declare their faults up front instead of toggling them by hand
+//
+// s := net.MakeSchedule()
+// s.At(500 * time.Millisecond).Crash(2)         - Server 2 fails to send/receive every RPC
+// s.AfterOps(10).Recover(2)                     - Server 2 works again after 10 operations
+// s.AfterOps(10).Partition("p", []int{1}, []int{2, 3})
+// s.At(2 * time.Second).Heal("p")               - Also HealAll(), LinkLoss() and LinkEnabled()
+// s.OnByzantine(fn)                             - fn(server, true/false) switches Byzantine mode
+// s.AfterOps(20).Byzantine(3)                   - Also Honest(3)
+// s.AfterOps(30).Do("name", fn)                 - Arbitrary action
+// s.Start()                                     - Start the timeline
+// s.Op()                                        - Report a completed operation (i.e. a Propose())
+// s.Stop()                                      - Cancel the remaining timed events
+//
+// Schedules can also be parsed from text, one event per line or separated by ';', with '@'
+// marking a time and '#' an operation count (i.e. from an environment variable):
+//
+// s, err := net.ParseSchedule("@500ms crash 2; #10 recover 2; #10 partition p 1 2,3; @2s heal p")
+//
+// => Events with the same trigger fire in the order they were added
+// => Operation-count events fire synchronously inside Op(), so the fault is in place before the
+//    workload's next operation starts; Op() only looks at the events that are due, so preloading
+//    many of them (i.e. one per benchmark iteration) doesn't slow it down
+// => Actions run without the schedule's lock held, so they may call its methods (i.e. Op() or
+//    Fired()); actions fired by concurrent calls to Op() may run concurrently
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Schedule struct {
+	mu        sync.Mutex
+	net       *Network
+	events    []*event
+	pending   []*event // Operation-count events yet to fire, by operation count (then as added)
+	ops       int
+	started   bool
+	done      chan bool
+	byzantine func(server int, byzantine bool)
+}
+
+type event struct {
+	at     time.Duration // Fire at this time after Start() (timed events)
+	ops    int           // Fire once this many operations have completed (operation-count events)
+	timed  bool
+	name   string
+	action func()
+	fired  bool
+}
+
+// Trigger of the events added through it
+type When struct {
+	s     *Schedule
+	at    time.Duration
+	ops   int
+	timed bool
+}
+
+func (rn *Network) MakeSchedule() *Schedule {
+	s := &Schedule{}
+	s.net = rn
+	s.events = []*event{}
+	s.pending = []*event{}
+	s.done = make(chan bool)
+	return s
+}
+
+func (s *Schedule) At(d time.Duration) *When {
+	return &When{s, d, 0, true}
+}
+
+func (s *Schedule) AfterOps(ops int) *When {
+	return &When{s, 0, ops, false}
+}
+
+func (s *Schedule) OnByzantine(fn func(server int, byzantine bool)) *Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byzantine = fn
+	return s
+}
+
+func (s *Schedule) Start() {
+	s.mu.Lock()
+	if s.started == true {
+		s.mu.Unlock()
+		return
+	}
+	s.started = true
+
+	for _, ev := range s.events {
+		if ev.timed == true {
+			go s.wait(ev)
+		}
+	}
+	due := s.dueOps() // Events after zero operations fire immediately
+	s.mu.Unlock()
+
+	s.fire(due)
+}
+
+// Report a completed operation, firing every event that was waiting for it
+func (s *Schedule) Op() {
+	s.mu.Lock()
+	s.ops++
+	due := []*event{}
+	if s.started == true {
+		due = s.dueOps()
+	}
+	s.mu.Unlock()
+
+	s.fire(due)
+}
+
+func (s *Schedule) Ops() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops
+}
+
+func (s *Schedule) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started == true {
+		select {
+		case <-s.done:
+		default:
+			close(s.done)
+		}
+	}
+}
+
+// Names of the events that have fired so far, in the order they were added
+func (s *Schedule) Fired() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := []string{}
+	for _, ev := range s.events {
+		if ev.fired == true {
+			names = append(names, ev.name)
+		}
+	}
+	return names
+}
+
+func (s *Schedule) wait(ev *event) {
+	select {
+	case <-s.done:
+		return
+	case <-s.net.clock.After(ev.at):
+	}
+
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return
+	default:
+		ev.fired = true
+	}
+	s.mu.Unlock()
+
+	s.fire([]*event{ev})
+}
+
+// Must hold s.mu; marks the operation-count events that are due as fired and returns them, for
+// the caller to fire once it has released s.mu
+func (s *Schedule) dueOps() []*event {
+	due := []*event{}
+	for len(s.pending) > 0 && s.pending[0].ops <= s.ops {
+		ev := s.pending[0]
+		s.pending = s.pending[1:]
+		ev.fired = true
+		due = append(due, ev)
+	}
+	return due
+}
+
+// Must hold s.mu
+func (s *Schedule) addPending(ev *event) {
+	i := sort.Search(len(s.pending), func(i int) bool { return s.pending[i].ops > ev.ops })
+	s.pending = append(s.pending, nil)
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = ev
+}
+
+// Must not hold s.mu, so that actions can call the schedule's methods (and a slow action doesn't
+// hold up Op() for everyone else)
+func (s *Schedule) fire(events []*event) {
+	for _, ev := range events {
+		dPrintf("Schedule: %s\n", ev.name)
+		ev.action()
+	}
+}
+
+//
+// ---------------------------------- EVENTS ----------------------------------
+//
+func (w *When) Do(name string, action func()) *Schedule {
+	s := w.s
+	s.mu.Lock()
+	ev := &event{w.at, w.ops, w.timed, name, action, false}
+	s.events = append(s.events, ev)
+	if ev.timed == false {
+		s.addPending(ev)
+	}
+	due := []*event{}
+	if s.started == true { // Added to a running schedule
+		if ev.timed == true {
+			go s.wait(ev)
+		} else {
+			due = s.dueOps()
+		}
+	}
+	s.mu.Unlock()
+
+	s.fire(due)
+	return s
+}
+
+func (w *When) Crash(server int) *Schedule {
+	return w.Do(fmt.Sprintf("crash %d", server), func() { w.s.net.SetFaultRate(server, 100) })
+}
+
+func (w *When) Recover(server int) *Schedule {
+	return w.Do(fmt.Sprintf("recover %d", server), func() { w.s.net.SetFaultRate(server, 0) })
+}
+
+func (w *When) Partition(name string, groups ...[]int) *Schedule {
+	return w.Do(fmt.Sprintf("partition %s %v", name, groups), func() { w.s.net.Partition(name, groups...) })
+}
+
+func (w *When) Heal(name string) *Schedule {
+	return w.Do("heal "+name, func() { w.s.net.Heal(name) })
+}
+
+func (w *When) HealAll() *Schedule {
+	return w.Do("healall", func() { w.s.net.HealAll() })
+}
+
+func (w *When) LinkLoss(src int, dst int, rate int) *Schedule {
+	return w.Do(fmt.Sprintf("loss %d %d %d", src, dst, rate), func() { w.s.net.SetLinkLoss(src, dst, rate) })
+}
+
+func (w *When) LinkEnabled(src int, dst int, enabled bool) *Schedule {
+	return w.Do(fmt.Sprintf("link %d %d %v", src, dst, enabled), func() { w.s.net.SetLinkEnabled(src, dst, enabled) })
+}
+
+func (w *When) Byzantine(server int) *Schedule {
+	return w.Do(fmt.Sprintf("byzantine %d", server), func() { w.s.setByzantine(server, true) })
+}
+
+func (w *When) Honest(server int) *Schedule {
+	return w.Do(fmt.Sprintf("honest %d", server), func() { w.s.setByzantine(server, false) })
+}
+
+func (s *Schedule) setByzantine(server int, byzantine bool) {
+	s.mu.Lock()
+	fn := s.byzantine
+	s.mu.Unlock()
+
+	if fn == nil {
+		dPrintf("Schedule: no OnByzantine() function to make server (%d) Byzantine\n", server)
+		return
+	}
+	fn(server, byzantine)
+}
+
+//
+// ---------------------------------- PARSER ----------------------------------
+//
+// Parse a textual schedule: "<trigger> <action> <args...>" per event, where the trigger is
+// "@<duration>" (i.e. "@1.5s") or "#<ops>" and the action is one of crash, recover, partition,
+// heal, healall, loss, link (up/down), byzantine or honest
+func (rn *Network) ParseSchedule(text string) (*Schedule, error) {
+	s := rn.MakeSchedule()
+
+	lines := strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ';' })
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "//") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid schedule event %q", line)
+		}
+
+		var w *When
+		if strings.HasPrefix(fields[0], "@") {
+			d, err := time.ParseDuration(fields[0][1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid schedule event %q: %v", line, err)
+			}
+			w = s.At(d)
+		} else if strings.HasPrefix(fields[0], "#") {
+			ops, err := strconv.Atoi(fields[0][1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid schedule event %q: %v", line, err)
+			}
+			w = s.AfterOps(ops)
+		} else {
+			return nil, fmt.Errorf("invalid schedule trigger %q", fields[0])
+		}
+
+		if err := w.parseAction(fields[1], fields[2:]); err != nil {
+			return nil, fmt.Errorf("invalid schedule event %q: %v", line, err)
+		}
+	}
+	return s, nil
+}
+
+func (w *When) parseAction(action string, args []string) error {
+	ints, err := parseInts(args)
+
+	switch {
+	case action == "crash" && len(args) == 1 && err == nil:
+		w.Crash(ints[0])
+	case action == "recover" && len(args) == 1 && err == nil:
+		w.Recover(ints[0])
+	case action == "byzantine" && len(args) == 1 && err == nil:
+		w.Byzantine(ints[0])
+	case action == "honest" && len(args) == 1 && err == nil:
+		w.Honest(ints[0])
+	case action == "loss" && len(args) == 3 && err == nil:
+		w.LinkLoss(ints[0], ints[1], ints[2])
+	case action == "heal" && len(args) == 1:
+		w.Heal(args[0])
+	case action == "healall" && len(args) == 0:
+		w.HealAll()
+	case action == "link" && len(args) == 3 && (args[2] == "up" || args[2] == "down"):
+		ends, err := parseInts(args[:2])
+		if err != nil {
+			return err
+		}
+		w.LinkEnabled(ends[0], ends[1], args[2] == "up")
+	case action == "partition" && len(args) >= 2:
+		groups := [][]int{}
+		for _, arg := range args[1:] {
+			group, err := parseInts(strings.Split(arg, ","))
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+		}
+		w.Partition(args[0], groups...)
+	default:
+		return fmt.Errorf("unknown action or wrong arguments")
+	}
+	return nil
+}
+
+func parseInts(args []string) ([]int, error) {
+	ints := []int{}
+	for _, arg := range args {
+		i, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
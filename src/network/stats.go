@@ -0,0 +1,97 @@
+package network
+
+// Per-method and per-link message accounting for the simulated network
+// The network counts every request and reply it delivers, together with the size of its encoded
+// payload, broken down by service method, direction and link, so that tests can assert message
+// complexity and benchmarks can report bytes per operation
+//
+// stats := net.Stats()   - Snapshot of the network's message counts and byte totals
+// net.ResetStats()       - Zero every count (i.e. after a test's setup phase)
+//
+// stats.Total()                                       - Every delivered message
+// stats.Sum(Filter{"XPaxos.Commit", ANY, ANY, false}) - Delivered commit requests
+// stats.ByMethod()                                    - Requests and replies of each service method
+//
+// => Only delivered messages are counted; dropped messages show up in traces (see trace.go)
+// => Server.GetCount() still counts every RPC dispatched to a server
+
+type StatsKey struct {
+	SvcMeth string
+	Src     int // Sending server (replying server for replies); ANY if not named by an int
+	Dst     int // Receiving server (calling server for replies); ANY if not named by an int
+	IsReply bool
+}
+
+type StatsEntry struct {
+	Count int // Number of messages
+	Bytes int // Total size of their encoded payloads
+}
+
+type Stats map[StatsKey]StatsEntry
+
+func (rn *Network) Stats() Stats {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	stats := Stats{}
+	for key, entry := range rn.stats {
+		stats[key] = entry
+	}
+	return stats
+}
+
+func (rn *Network) ResetStats() {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.stats = Stats{}
+}
+
+func (stats Stats) Total() StatsEntry {
+	total := StatsEntry{}
+	for _, entry := range stats {
+		total = total.add(entry)
+	}
+	return total
+}
+
+// Sum the messages matching filter (exactly as for interceptors; see intercept.go)
+func (stats Stats) Sum(filter Filter) StatsEntry {
+	sum := StatsEntry{}
+	for key, entry := range stats {
+		m := &Intercepted{SvcMeth: key.SvcMeth, Src: key.Src, Dst: key.Dst, IsReply: key.IsReply}
+		if filter.matches(m) {
+			sum = sum.add(entry)
+		}
+	}
+	return sum
+}
+
+func (stats Stats) ByMethod() map[string]StatsEntry {
+	methods := map[string]StatsEntry{}
+	for key, entry := range stats {
+		methods[key.SvcMeth] = methods[key.SvcMeth].add(entry)
+	}
+	return methods
+}
+
+func (e StatsEntry) add(other StatsEntry) StatsEntry {
+	return StatsEntry{e.Count + other.Count, e.Bytes + other.Bytes}
+}
+
+func (rn *Network) count(svcMeth string, src interface{}, dst interface{}, isReply bool, size int) {
+	srcId, ok := src.(int)
+	if ok == false {
+		srcId = ANY
+	}
+	dstId, ok := dst.(int)
+	if ok == false {
+		dstId = ANY
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	key := StatsKey{svcMeth, srcId, dstId, isReply}
+	rn.stats[key] = rn.stats[key].add(StatsEntry{1, size})
+}
@@ -0,0 +1,430 @@
+package network
+
+// TCP transport offering the same Call() semantics as the channel-based simulator
+// Requests and replies are gob-encoded frames multiplexed over a single TCP connection per
+// client endpoint, so replicas can run as separate OS processes (on localhost or on real
+// machines) without any change to the protocol code
+//
+// srv := MakeServer()                - Holds a collection of services (exactly as for the simulator)
+// srv.AddService(svc)
+// l, err := ListenTCP(addr, srv)     - Serve srv on addr (i.e. "localhost:5000")
+// l.Close()                          - Stop accepting connections and close open ones
+//
+// end := MakeTCPEnd(addr, timeout)   - Create a client endpoint to talk to the server on addr
+// end.Call("XPaxos.Replicate", args, &reply, callerId)
+// end.CallWithTimeout("XPaxos.Replicate", args, &reply, callerId, timeout)
+// end.Go("XPaxos.Prepare", args, &reply, callerId, done)
+// end.CallErr("XPaxos.Replicate", args, &reply, callerId) - Like Call() but returns an error
+// end.Send("XPaxos.Ping", args, callerId)
+// => Call() returns false if the connection fails or no reply arrives within timeout
+// => The connection is (re-)established lazily, so servers may start after their clients
+// end.Close()                        - Close the endpoint's connection
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+	"sync"
+	"time"
+)
+
+const WRITETIMEOUT = 1000 // Longest a server waits to write a reply to a connection (in milliseconds)
+
+type tcpRequest struct {
+	Seq      uint64
+	SvcMeth  string
+	CallerId int
+	Args     []byte
+}
+
+type tcpReply struct {
+	Seq   uint64
+	Ok    bool
+	Reply []byte
+	Err   string // Kind of error if the server rejected the request (see errors.go)
+	Why   string
+}
+
+type TCPListener struct {
+	mu     sync.Mutex
+	l      net.Listener
+	server *Server
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+type TCPClientEnd struct {
+	mu      sync.Mutex
+	addr    string
+	timeout time.Duration
+	conn    net.Conn
+	enc     *gob.Encoder
+	seq     uint64
+	pending map[uint64]func(rep tcpReply) // Completes each call waiting for its reply (without e.mu held)
+}
+
+//
+// ------------------------------ SERVER FUNCTIONS ----------------------------
+//
+func ListenTCP(addr string, rs *Server) (*TCPListener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tl := &TCPListener{}
+	tl.l = l
+	tl.server = rs
+	tl.conns = map[net.Conn]bool{}
+
+	go tl.accept()
+
+	return tl, nil
+}
+
+func (tl *TCPListener) Addr() string {
+	return tl.l.Addr().String()
+}
+
+func (tl *TCPListener) Close() {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tl.closed = true
+	tl.l.Close()
+	for conn, _ := range tl.conns {
+		conn.Close()
+	}
+}
+
+func (tl *TCPListener) accept() {
+	for {
+		conn, err := tl.l.Accept()
+		if err != nil {
+			return
+		}
+
+		tl.mu.Lock()
+		if tl.closed == true {
+			tl.mu.Unlock()
+			conn.Close()
+			return
+		}
+		tl.conns[conn] = true
+		tl.mu.Unlock()
+
+		go tl.serve(conn)
+	}
+}
+
+func (tl *TCPListener) serve(conn net.Conn) {
+	var wmu sync.Mutex // Serialises replies from concurrent handlers
+
+	defer func() {
+		tl.mu.Lock()
+		delete(tl.conns, conn)
+		tl.mu.Unlock()
+		conn.Close()
+	}()
+
+	dec := gob.NewDecoder(conn)
+	enc := gob.NewEncoder(conn)
+
+	for {
+		treq := tcpRequest{}
+		if err := dec.Decode(&treq); err != nil {
+			return
+		}
+
+		go func(treq tcpRequest) { // Handlers may block, so execute each request in its own thread
+			req := reqMsg{}
+			req.svcMeth = treq.SvcMeth
+			req.args = treq.Args
+			req.callerId = treq.CallerId
+
+			rep := tl.server.dispatch(req)
+
+			wmu.Lock()
+			defer wmu.Unlock()
+			trep := tcpReply{treq.Seq, rep.ok, rep.reply, "", ""}
+			if rpcErr, ok := rep.err.(*RPCError); ok {
+				trep.Err = rpcErr.Err.Error()
+				trep.Why = rpcErr.Detail
+			}
+			// A client that stops reading must not hold wmu (and so every other reply) forever
+			conn.SetWriteDeadline(time.Now().Add(WRITETIMEOUT * time.Millisecond))
+			if err := enc.Encode(trep); err != nil {
+				// The gob stream is broken, so the client has to reconnect
+				dPrintf("TCPListener.serve(): encode reply: %v\n", err)
+				conn.Close()
+			}
+		}(treq)
+	}
+}
+
+//
+// ------------------------------ CLIENT FUNCTIONS ----------------------------
+//
+func MakeTCPEnd(addr string, timeout time.Duration) *TCPClientEnd {
+	e := &TCPClientEnd{}
+	e.addr = addr
+	e.timeout = timeout
+	e.pending = map[uint64]func(rep tcpReply){}
+	return e
+}
+
+func (e *TCPClientEnd) Call(svcMeth string, args interface{}, reply interface{}, callerId int) bool {
+	qb := new(bytes.Buffer)
+	qe := gob.NewEncoder(qb)
+	if err := qe.Encode(args); err != nil {
+		dPrintf("TCPClientEnd.Call(): encode args: %v\n", err)
+		return false
+	}
+
+	return e.call(svcMeth, qb.Bytes(), reply, callerId, e.timeout) == nil
+}
+
+// Like Call() but returns why the call failed (see errors.go)
+func (e *TCPClientEnd) CallErr(svcMeth string, args interface{}, reply interface{}, callerId int) error {
+	qb := new(bytes.Buffer)
+	qe := gob.NewEncoder(qb)
+	if err := qe.Encode(args); err != nil {
+		return err
+	}
+
+	return e.call(svcMeth, qb.Bytes(), reply, callerId, e.timeout)
+}
+
+func (e *TCPClientEnd) CallWithTimeout(svcMeth string, args interface{}, reply interface{}, callerId int,
+	timeout time.Duration) bool {
+	qb := new(bytes.Buffer)
+	qe := gob.NewEncoder(qb)
+	if err := qe.Encode(args); err != nil {
+		dPrintf("TCPClientEnd.CallWithTimeout(): encode args: %v\n", err)
+		return false
+	}
+
+	return e.call(svcMeth, qb.Bytes(), reply, callerId, timeout) == nil
+}
+
+// The connection's reader completes the call (or a timer, if no reply arrives in time), so only a
+// call that has to wait for the connection to be dialed costs a goroutine
+func (e *TCPClientEnd) Go(svcMeth string, args interface{}, reply interface{}, callerId int, done chan *Call) *Call {
+	call := makeCall(svcMeth, args, reply, e.addr, done)
+
+	qb := new(bytes.Buffer)
+	qe := gob.NewEncoder(qb)
+	if err := qe.Encode(args); err != nil {
+		call.done(err)
+		return call
+	}
+
+	start := func() {
+		seq, err := e.start(svcMeth, qb.Bytes(), callerId, e.timeout, func(rep tcpReply) {
+			call.done(decodeTCPReply(svcMeth, rep, reply))
+		})
+		if err != nil {
+			call.done(err)
+			return
+		}
+		time.AfterFunc(e.timeout, func() {
+			if e.cancel(seq) == true {
+				call.done(ErrFailed)
+			}
+		})
+	}
+
+	e.mu.Lock()
+	connected := e.conn != nil
+	e.mu.Unlock()
+	if connected == true {
+		start()
+	} else {
+		go start()
+	}
+	return call
+}
+
+func (e *TCPClientEnd) Send(svcMeth string, args interface{}, callerId int) {
+	qb := new(bytes.Buffer)
+	qe := gob.NewEncoder(qb)
+	if err := qe.Encode(args); err != nil {
+		dPrintf("TCPClientEnd.Send(): encode args: %v\n", err)
+		return
+	}
+
+	go e.call(svcMeth, qb.Bytes(), nil, callerId, e.timeout)
+}
+
+func (e *TCPClientEnd) Peer() interface{} {
+	return e.addr
+}
+
+// A nil reply means the caller is not interested in the reply's contents
+func (e *TCPClientEnd) call(svcMeth string, args []byte, reply interface{}, callerId int, timeout time.Duration) error {
+	replyCh := make(chan tcpReply, 1)
+	seq, err := e.start(svcMeth, args, callerId, timeout, func(rep tcpReply) { replyCh <- rep })
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case rep := <-replyCh:
+		return decodeTCPReply(svcMeth, rep, reply)
+	case <-timer.C:
+		e.cancel(seq)
+		return ErrFailed
+	}
+}
+
+// Send a request, registering complete to be called with its reply
+func (e *TCPClientEnd) start(svcMeth string, args []byte, callerId int, timeout time.Duration,
+	complete func(rep tcpReply)) (uint64, error) {
+	if err := e.dial(); err != nil {
+		return 0, ErrFailed
+	}
+
+	e.mu.Lock()
+	if e.conn == nil { // Closed since it was dialed
+		e.mu.Unlock()
+		return 0, ErrFailed
+	}
+
+	e.seq++
+	seq := e.seq
+	e.pending[seq] = complete
+
+	e.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err := e.enc.Encode(tcpRequest{seq, svcMeth, callerId, args}); err != nil {
+		delete(e.pending, seq)
+		pending := e.closeConn()
+		e.mu.Unlock()
+		failPending(pending)
+		return 0, ErrFailed
+	}
+	e.mu.Unlock()
+	return seq, nil
+}
+
+// Stop waiting for the reply to a request; reports whether it was still pending
+func (e *TCPClientEnd) cancel(seq uint64) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.pending[seq]
+	delete(e.pending, seq)
+	return ok
+}
+
+func decodeTCPReply(svcMeth string, rep tcpReply, reply interface{}) error {
+	if rep.Ok == false {
+		return rep.err(svcMeth)
+	} else if reply == nil {
+		return nil
+	}
+	rd := gob.NewDecoder(bytes.NewBuffer(rep.Reply))
+	if err := rd.Decode(reply); err != nil {
+		dPrintf("TCPClientEnd.Call(): decode reply: %v\n", err)
+		return &RPCError{svcMeth, ErrDecode, err.Error()}
+	}
+	return nil
+}
+
+// Map a failed reply back to the server's typed error
+func (rep tcpReply) err(svcMeth string) error {
+	for _, kind := range []error{ErrUnknownService, ErrUnknownMethod, ErrDecode, ErrHandler} {
+		if rep.Err == kind.Error() {
+			return &RPCError{svcMeth, kind, rep.Why}
+		}
+	}
+	return ErrFailed
+}
+
+func (e *TCPClientEnd) Close() {
+	e.mu.Lock()
+	var pending map[uint64]func(rep tcpReply)
+	if e.conn != nil {
+		pending = e.closeConn()
+	}
+	e.mu.Unlock()
+
+	failPending(pending)
+}
+
+// Connects unless already connected; dials without holding e.mu, so that an unreachable server
+// doesn't hold up every other call on the endpoint (or Close()) for the dial timeout
+func (e *TCPClientEnd) dial() error {
+	e.mu.Lock()
+	connected := e.conn != nil
+	e.mu.Unlock()
+	if connected == true {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", e.addr, e.timeout)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil { // Another call connected first
+		conn.Close()
+		return nil
+	}
+
+	e.conn = conn
+	e.enc = gob.NewEncoder(conn)
+	go e.read(conn, gob.NewDecoder(conn))
+
+	return nil
+}
+
+// Must hold e.mu; returns the calls pending on the old connection, which the caller fails with
+// failPending() once it released e.mu
+func (e *TCPClientEnd) closeConn() map[uint64]func(rep tcpReply) {
+	e.conn.Close()
+	e.conn = nil
+	e.enc = nil
+
+	pending := e.pending
+	e.pending = map[uint64]func(rep tcpReply){}
+	return pending
+}
+
+func failPending(pending map[uint64]func(rep tcpReply)) {
+	for seq, complete := range pending {
+		complete(tcpReply{seq, false, nil, "", ""})
+	}
+}
+
+func (e *TCPClientEnd) read(conn net.Conn, dec *gob.Decoder) {
+	for {
+		rep := tcpReply{}
+		if err := dec.Decode(&rep); err != nil {
+			e.mu.Lock()
+			var pending map[uint64]func(rep tcpReply)
+			if e.conn == conn {
+				pending = e.closeConn()
+			}
+			e.mu.Unlock()
+
+			failPending(pending)
+			return
+		}
+
+		// The reply is decoded into the caller's reply without e.mu held, so that a large or slow
+		// reply doesn't hold up the endpoint's other calls
+		e.mu.Lock()
+		complete, ok := e.pending[rep.Seq]
+		delete(e.pending, rep.Seq)
+		e.mu.Unlock()
+
+		if ok == true {
+			complete(rep)
+		}
+	}
+}
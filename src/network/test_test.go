@@ -0,0 +1,717 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Echo struct {
+	mu    sync.Mutex
+	calls int
+}
+
+type EchoArgs struct {
+	Value   int
+	SleepMs int
+}
+
+func (echo *Echo) Echo(args EchoArgs, reply *int) {
+	echo.mu.Lock()
+	echo.calls++
+	echo.mu.Unlock()
+
+	time.Sleep(time.Duration(args.SleepMs) * time.Millisecond)
+	*reply = args.Value
+}
+
+// Keeps the CPU busy for ms of real time (i.e. like a server signing a message)
+func (echo *Echo) Busy(ms int, reply *int) {
+	start := time.Now()
+	for time.Since(start) < time.Duration(ms)*time.Millisecond {
+		*reply++
+	}
+}
+
+func (echo *Echo) Size(args []byte, reply *int) {
+	*reply = len(args)
+}
+
+func makeEchoServer() *Server {
+	srv := MakeServer()
+	srv.AddService(MakeService(&Echo{}))
+	return srv
+}
+
+//
+// ------------------------------ TEST FUNCTIONS ------------------------------
+//
+func TestTCPCall(t *testing.T) {
+	fmt.Println("Test: TCP Transport - Call")
+
+	l, err := ListenTCP("127.0.0.1:0", makeEchoServer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	end := MakeTCPEnd(l.Addr(), time.Second)
+	defer end.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ { // Concurrent calls share one connection
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply := 0
+			if ok := end.Call("Echo.Echo", EchoArgs{i, 0}, &reply, 1); ok == false || reply != i {
+				t.Errorf("Invalid reply (ok=%v, reply=%d, expected=%d)!", ok, reply, i)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestTCPTimeout(t *testing.T) {
+	fmt.Println("Test: TCP Transport - Timeout and Reconnect")
+
+	l, err := ListenTCP("127.0.0.1:0", makeEchoServer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr()
+
+	end := MakeTCPEnd(addr, 100*time.Millisecond)
+	defer end.Close()
+
+	reply := 0
+	if ok := end.Call("Echo.Echo", EchoArgs{1, 500}, &reply, 1); ok == true {
+		t.Fatal("Call should time out!")
+	}
+
+	l.Close()
+	if ok := end.Call("Echo.Echo", EchoArgs{2, 0}, &reply, 1); ok == true {
+		t.Fatal("Call to a closed server should fail!")
+	}
+
+	l, err = ListenTCP(addr, makeEchoServer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if ok := end.Call("Echo.Echo", EchoArgs{3, 0}, &reply, 1); ok == false || reply != 3 {
+		t.Fatal("Call should succeed after the server restarts!")
+	}
+}
+
+func TestLinkPartition(t *testing.T) {
+	fmt.Println("Test: Simulated Network - Per-Link Partitions")
+
+	net := MakeNetwork()
+	ends := map[link]*ClientEnd{}
+	for src := 1; src <= 3; src++ {
+		for dst := 1; dst <= 3; dst++ {
+			endname := fmt.Sprintf("%d-%d", src, dst)
+			ends[link{src, dst}] = net.MakeEnd(endname)
+			net.Connect(endname, dst)
+			net.Enable(endname, true)
+		}
+		net.AddServer(src, makeEchoServer())
+	}
+
+	call := func(src int, dst int) bool {
+		reply := 0
+		return ends[link{src, dst}].Call("Echo.Echo", EchoArgs{src, 0}, &reply, src)
+	}
+
+	net.SetLinkEnabled(1, 2, false) // Requests from 1 to 2 are lost
+	if call(1, 2) == true || call(2, 1) == true {
+		t.Fatal("Calls over a disabled link should fail!")
+	}
+	if call(1, 3) == false || call(3, 2) == false {
+		t.Fatal("Calls over enabled links should succeed!")
+	}
+	net.SetLinkEnabled(1, 2, true)
+
+	net.Partition("p", []int{1}, []int{2, 3})
+	if call(1, 2) == true || call(3, 1) == true {
+		t.Fatal("Calls across a partition should fail!")
+	}
+	if call(2, 3) == false {
+		t.Fatal("Calls within a partition should succeed!")
+	}
+
+	net.Heal("p")
+	if call(1, 2) == false || call(3, 1) == false {
+		t.Fatal("Calls should succeed after the partition heals!")
+	}
+
+	net.SetLinkLoss(2, 3, 100)
+	if call(2, 3) == true {
+		t.Fatal("Calls over a lossy link should fail!")
+	}
+	net.HealAll()
+	if call(2, 3) == false {
+		t.Fatal("Calls should succeed after all links heal!")
+	}
+}
+
+func TestVirtualClock(t *testing.T) {
+	fmt.Println("Test: Simulated Network - Virtual Clock")
+
+	vc := MakeVirtualClock()
+	defer vc.Stop()
+
+	start := time.Now()
+	t0 := vc.Now()
+
+	ch1 := vc.After(2 * time.Second)
+	ch2 := vc.After(1 * time.Second)
+	vc.Sleep(10 * time.Second)
+
+	if vc.Now().Sub(t0) != 10*time.Second {
+		t.Fatalf("Invalid virtual time (%v)!", vc.Now().Sub(t0))
+	}
+	if at1, at2 := <-ch1, <-ch2; at2.After(at1) || at1.Sub(t0) != 2*time.Second {
+		t.Fatal("Timers fired out of order!")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Virtual clock is too slow (%v)!", time.Since(start))
+	}
+
+	net := MakeSimNetwork(518) // Time doesn't pass while a handler computes
+	defer net.Cleanup()
+	if net.Clock().(*VirtualClock).samples == nil {
+		t.Skip("No scheduler metrics, so a busy server can see timers fire early")
+	}
+	net.AddServer(2, makeEchoServer())
+	end := net.MakeEnd("1-2")
+	net.Connect("1-2", 2)
+	net.Enable("1-2", true)
+
+	reply := 0
+	start = time.Now()
+	if ok := end.CallWithTimeout("Echo.Busy", 50, &reply, 1, time.Millisecond); ok == false {
+		t.Fatalf("Virtual timer fired while the server was busy (after %v)!", time.Since(start))
+	}
+}
+
+func TestSameSeed(t *testing.T) {
+	fmt.Println("Test: Simulated Network - Same Seed, Same Run")
+
+	run := func(seed int64) []TraceEvent {
+		net := MakeSimNetwork(seed)
+		defer net.Cleanup()
+		ends := []*ClientEnd{}
+		for server := 2; server <= 3; server++ {
+			endname := fmt.Sprintf("1-%d", server)
+			net.AddServer(server, makeEchoServer())
+			ends = append(ends, net.MakeEnd(endname))
+			net.Connect(endname, server)
+			net.Enable(endname, true)
+		}
+		// Each goroutine calls from its own caller ID, so that every link carries one message at a
+		// time: the order of concurrent messages on one link is up to the Go runtime (see clock.go)
+		net.Reliable(false)
+		for i := 0; i < 20; i++ {
+			net.SetLinkLoss(10+i, 3, 30)
+			net.SetLinkLatency(10+i, 2, NormalLatency{80 * time.Millisecond, 20 * time.Millisecond})
+			net.SetLinkLatency(3, 10+i, ParetoLatency{40 * time.Millisecond, 2.5})
+		}
+
+		path := t.TempDir() + "/trace.jsonl"
+		if err := net.StartTrace(path); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				reply := 0
+				for j := 0; j < 5; j++ {
+					ends[i%2].CallWithTimeout("Echo.Echo", EchoArgs{5*i + j, 0}, &reply, 10+i, time.Second)
+					net.Clock().Sleep(time.Duration(i) * time.Millisecond)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if err := net.StopTrace(); err != nil {
+			t.Fatal(err)
+		}
+
+		events, err := ReadTrace(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Events of the same instant are recorded in whatever order their goroutines run in
+		sort.SliceStable(events, func(i, j int) bool {
+			a, b := events[i], events[j]
+			if a.Time != b.Time {
+				return a.Time < b.Time
+			}
+			if a.IsReply != b.IsReply {
+				return b.IsReply
+			}
+			if a.Outcome != b.Outcome {
+				return a.Outcome < b.Outcome
+			}
+			return bytes.Compare(a.Payload, b.Payload) < 0
+		})
+		return events
+	}
+
+	same := func(events1 []TraceEvent, events2 []TraceEvent) bool {
+		if len(events1) != len(events2) {
+			return false
+		}
+		for i := range events1 {
+			a, b := events1[i], events2[i]
+			if a.Time != b.Time || a.Src != b.Src || a.Dst != b.Dst || a.IsReply != b.IsReply ||
+				a.Outcome != b.Outcome || bytes.Equal(a.Payload, b.Payload) == false {
+				t.Logf("Event %d differs: %+v vs. %+v", i, a, b)
+				return false
+			}
+		}
+		return true
+	}
+
+	events := run(518)
+	if same(events, run(518)) == false {
+		t.Fatal("Runs with the same seed differ!")
+	}
+	if same(events, run(519)) == true {
+		t.Fatal("Runs with different seeds are identical!")
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	fmt.Println("Test: Simulated Network - Interceptors")
+
+	net := MakeNetwork()
+	echo := &Echo{}
+	srv := MakeServer()
+	srv.AddService(MakeService(echo))
+	net.AddServer(2, srv)
+
+	end := net.MakeEnd("1-2")
+	net.Connect("1-2", 2)
+	net.Enable("1-2", true)
+
+	call := func(value int) (bool, int) {
+		reply := 0
+		ok := end.Call("Echo.Echo", EchoArgs{value, 0}, &reply, 1)
+		return ok, reply
+	}
+
+	id := net.AddInterceptor(Filter{SvcMeth: "Echo.Echo", Src: 1, Dst: 2}, func(m *Intercepted) {
+		args := EchoArgs{}
+		m.Decode(&args)
+		args.Value *= 10
+		m.Encode(args)
+	})
+	if ok, reply := call(7); ok == false || reply != 70 {
+		t.Fatalf("Request was not modified (reply=%d)!", reply)
+	}
+	net.RemoveInterceptor(id)
+
+	id = net.AddInterceptor(Filter{Src: 2, Dst: ANY, Replies: true}, func(m *Intercepted) {
+		m.Drop = true
+	})
+	if ok, _ := call(7); ok == true {
+		t.Fatal("Reply was not dropped!")
+	}
+	net.RemoveInterceptor(id)
+
+	id = net.AddInterceptor(Filter{Src: ANY, Dst: ANY}, func(m *Intercepted) {
+		m.Duplicates = 2
+	})
+	echo.mu.Lock()
+	before := echo.calls
+	echo.mu.Unlock()
+	call(7)
+	time.Sleep(100 * time.Millisecond)
+	echo.mu.Lock()
+	if echo.calls-before != 3 {
+		t.Fatalf("Request was not duplicated (%d calls)!", echo.calls-before)
+	}
+	echo.mu.Unlock()
+	net.RemoveInterceptor(id)
+
+	reply := 0
+	if ok := net.Inject(3, 2, "Echo.Echo", EchoArgs{9, 0}, &reply); ok == false || reply != 9 {
+		t.Fatal("Injected request was not delivered!")
+	}
+}
+
+func TestLinkLatency(t *testing.T) {
+	fmt.Println("Test: Simulated Network - Link Latency")
+
+	for _, spec := range []string{"5", "const:5", "normal:80,5", "pareto:40,2.5", "empirical:78,80,95"} {
+		if _, err := ParseLatency(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, spec := range []string{"", "fast", "normal:80", "pareto:40,0", "const:-1"} {
+		if _, err := ParseLatency(spec); err == nil {
+			t.Fatalf("Invalid latency %q was accepted!", spec)
+		}
+	}
+
+	net := MakeSimNetwork(518)
+	defer net.Cleanup()
+	net.AddServer(2, makeEchoServer())
+	end := net.MakeEnd("1-2")
+	net.Connect("1-2", 2)
+	net.Enable("1-2", true)
+
+	path := t.TempDir() + "/latency.txt"
+	matrix := "# Test matrix\n0 0 0\n0 0 const:50\n0 empirical:30,30 0\n"
+	if err := os.WriteFile(path, []byte(matrix), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := net.LoadLatencyMatrix(path); err != nil {
+		t.Fatal(err)
+	}
+
+	start := net.Clock().Now()
+	reply := 0
+	if ok := end.Call("Echo.Echo", EchoArgs{1, 0}, &reply, 1); ok == false {
+		t.Fatal("Call failed!")
+	}
+	if rtt := net.Clock().Now().Sub(start); rtt < 80*time.Millisecond || rtt > 90*time.Millisecond {
+		t.Fatalf("Invalid round-trip time (%v)!", rtt)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	fmt.Println("Test: Simulated Network - Bandwidth")
+
+	net := MakeSimNetwork(518)
+	defer net.Cleanup()
+	net.AddServer(2, makeEchoServer())
+	end := net.MakeEnd("1-2")
+	net.Connect("1-2", 2)
+	net.Enable("1-2", true)
+
+	net.SetBandwidth(1, 8*Mbps, 0) // 1 MB/s uplink
+	if transmitTime(1000000, 8*Mbps) != time.Second {
+		t.Fatalf("Invalid transmission time (%v)!", transmitTime(1000000, 8*Mbps))
+	}
+
+	size := func(n int) (bool, time.Duration) {
+		start := net.Clock().Now()
+		reply := 0
+		ok := end.Call("Echo.Size", make([]byte, n), &reply, 1)
+		return ok && reply == n, net.Clock().Now().Sub(start)
+	}
+
+	if ok, rtt := size(100000); ok == false || rtt < 100*time.Millisecond || rtt > 110*time.Millisecond {
+		t.Fatalf("Invalid round-trip time for 100 kB (%v)!", rtt)
+	}
+
+	var wg sync.WaitGroup // Concurrent messages queue behind each other on the uplink
+	rtts := make([]time.Duration, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, rtts[i] = size(100000)
+		}(i)
+	}
+	wg.Wait()
+	if rtts[0] < 200*time.Millisecond && rtts[1] < 200*time.Millisecond {
+		t.Fatalf("Messages did not queue on the uplink (%v, %v)!", rtts[0], rtts[1])
+	}
+}
+
+func TestTraceReplay(t *testing.T) {
+	fmt.Println("Test: Simulated Network - Trace and Replay")
+
+	net := MakeNetwork()
+	net.AddServer(2, makeEchoServer())
+	end := net.MakeEnd("1-2")
+	net.Connect("1-2", 2)
+	net.Enable("1-2", true)
+
+	path := t.TempDir() + "/trace.jsonl"
+	if err := net.StartTrace(path); err != nil {
+		t.Fatal(err)
+	}
+	reply := 0
+	for i := 0; i < 5; i++ {
+		end.Call("Echo.Echo", EchoArgs{i, 0}, &reply, 1)
+	}
+	net.SetLinkEnabled(1, 2, false)
+	end.Call("Echo.Echo", EchoArgs{5, 0}, &reply, 1)
+	if err := net.StopTrace(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := ReadTrace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 11 {
+		t.Fatalf("Invalid number of trace events (%d)!", len(events))
+	}
+	if ev := events[1]; ev.IsReply == false || ev.Src != 2 || ev.Dst != 1 || ev.Outcome != DELIVERED ||
+		string(ev.Decoded) != "0" {
+		t.Fatalf("Invalid reply trace event (%+v)!", ev)
+	}
+	if ev := events[10]; ev.SvcMeth != "Echo.Echo" || ev.Outcome != DROPPED ||
+		string(ev.Decoded) != `{"Value":5,"SleepMs":0}` {
+		t.Fatalf("Invalid request trace event (%+v)!", ev)
+	}
+
+	echo := &Echo{} // Replay the trace into a fresh server
+	srv := MakeServer()
+	srv.AddService(MakeService(echo))
+	replay := MakeSimNetwork(518)
+	defer replay.Cleanup()
+	replay.AddServer(2, srv)
+	replay.Replay(events)
+	time.Sleep(100 * time.Millisecond)
+
+	echo.mu.Lock()
+	defer echo.mu.Unlock()
+	if echo.calls != 5 {
+		t.Fatalf("Invalid number of replayed requests (%d)!", echo.calls)
+	}
+}
+
+func TestStats(t *testing.T) {
+	fmt.Println("Test: Simulated Network - Message Accounting")
+
+	net := MakeNetwork()
+	net.AddServer(2, makeEchoServer())
+	end := net.MakeEnd("1-2")
+	net.Connect("1-2", 2)
+	net.Enable("1-2", true)
+
+	reply := 0
+	end.Call("Echo.Echo", EchoArgs{1, 0}, &reply, 1)
+	net.ResetStats()
+	for i := 0; i < 3; i++ {
+		end.Call("Echo.Echo", EchoArgs{i, 0}, &reply, 1)
+		end.Call("Echo.Size", make([]byte, 1000), &reply, 1)
+	}
+	net.SetLinkEnabled(1, 2, false) // Dropped messages are not counted
+	end.Call("Echo.Size", make([]byte, 1000), &reply, 1)
+
+	stats := net.Stats()
+	if total := stats.Total(); total.Count != 12 {
+		t.Fatalf("Invalid number of messages (%d)!", total.Count)
+	}
+	if sizes := stats.Sum(Filter{"Echo.Size", 1, 2, false}); sizes.Count != 3 || sizes.Bytes < 3000 {
+		t.Fatalf("Invalid Echo.Size request accounting (%+v)!", sizes)
+	}
+	if replies := stats.Sum(Filter{"", 2, ANY, true}); replies.Count != 6 {
+		t.Fatalf("Invalid reply accounting (%+v)!", replies)
+	}
+	if methods := stats.ByMethod(); len(methods) != 2 || methods["Echo.Echo"].Count != 6 {
+		t.Fatalf("Invalid per-method accounting (%+v)!", methods)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	fmt.Println("Test: Simulated Network - Fault Schedule")
+
+	for _, text := range []string{"crash 2", "@1s", "@1x crash 2", "#1 crash", "#1 explode 2", "#1 partition p 1,a"} {
+		if _, err := MakeNetwork().ParseSchedule(text); err == nil {
+			t.Fatalf("Invalid schedule %q was accepted!", text)
+		}
+	}
+
+	net := MakeSimNetwork(518)
+	defer net.Cleanup()
+
+	byzantine := map[int]bool{}
+	s, err := net.ParseSchedule("#0 crash 1; #2 recover 1\n#2 partition p 1 2,3; @1s heal p; #3 byzantine 3; @2s loss 1 2 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.OnByzantine(func(server int, b bool) { byzantine[server] = b })
+
+	start := net.Clock().Now()
+	s.Start()
+	defer s.Stop()
+
+	if net.faultDrops(1, MakeRand(0)) == false || len(s.Fired()) != 1 {
+		t.Fatalf("Operation-count events did not fire on Start() (%v)!", s.Fired())
+	}
+	s.Op()
+	s.Op()
+	if net.faultDrops(1, MakeRand(0)) == true || net.LinkUp(1, 2) == true || net.LinkUp(2, 3) == false {
+		t.Fatalf("Operation-count events did not fire in Op() (%v)!", s.Fired())
+	}
+
+	net.Clock().Sleep(1500 * time.Millisecond)
+	if net.LinkUp(1, 2) == false {
+		t.Fatalf("Timed event did not fire (%v at %v)!", s.Fired(), net.Clock().Now().Sub(start))
+	}
+
+	s.Op()
+	if byzantine[3] == false {
+		t.Fatal("Byzantine event did not fire!")
+	}
+
+	s.Stop() // Remaining timed events are cancelled
+	net.Clock().Sleep(time.Second)
+	if fired := s.Fired(); len(fired) != 5 || fired[4] != "byzantine 3" {
+		t.Fatalf("Invalid fired events (%v)!", fired)
+	}
+
+	s = net.MakeSchedule() // Operation-count events fire by count, whatever order they were added in
+	order := []int{}
+	for _, ops := range []int{3, 1, 2, 1} {
+		ops := ops
+		s.AfterOps(ops).Do(fmt.Sprintf("op %d", ops), func() { order = append(order, ops) })
+	}
+	s.Start()
+	for i := 0; i < 3; i++ {
+		s.Op()
+		if len(order) == 0 || order[len(order)-1] != i+1 {
+			t.Fatalf("Operation-count events fired out of order (%v)!", order)
+		}
+	}
+	if len(order) != 4 {
+		t.Fatalf("Invalid fired events (%v)!", order)
+	}
+
+	s = net.MakeSchedule() // Actions may call the schedule's methods
+	s.AfterOps(1).Do("chain", func() { s.Op() })
+	s.AfterOps(2).Do("fired", func() { s.Fired() })
+	s.Start()
+
+	done := make(chan bool)
+	go func() {
+		s.Op()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second): // Real time, as nothing waits on the virtual clock
+		t.Fatal("Action calling the schedule's methods deadlocked!")
+	}
+	if fired := s.Fired(); len(fired) != 2 || s.Ops() != 2 {
+		t.Fatalf("Invalid fired events (%v after %d operations)!", fired, s.Ops())
+	}
+}
+
+func TestCallWithTimeout(t *testing.T) {
+	fmt.Println("Test: Simulated Network and TCP Transport - Deadlines and Asynchronous Calls")
+
+	net := MakeNetwork()
+	net.SetDeadPoll(10 * time.Millisecond)
+	net.AddServer(2, makeEchoServer())
+	end := net.MakeEnd("1-2")
+	net.Connect("1-2", 2)
+	net.Enable("1-2", true)
+
+	l, err := ListenTCP("127.0.0.1:0", makeEchoServer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tcpEnd := MakeTCPEnd(l.Addr(), time.Second)
+	defer tcpEnd.Close()
+
+	for _, tr := range []Transport{end, tcpEnd} {
+		reply := 0
+		if ok := tr.CallWithTimeout("Echo.Echo", EchoArgs{1, 200}, &reply, 1, 50*time.Millisecond); ok == true {
+			t.Fatal("Call should time out!")
+		}
+		time.Sleep(200 * time.Millisecond)
+		if reply != 0 {
+			t.Fatal("Late reply was written after the call timed out!")
+		}
+		if ok := tr.CallWithTimeout("Echo.Echo", EchoArgs{2, 0}, &reply, 1, time.Second); ok == false || reply != 2 {
+			t.Fatalf("Invalid reply (ok=%v, reply=%d)!", ok, reply)
+		}
+
+		done := make(chan *Call, 5) // Fan out over one channel
+		replies := make([]int, 5)
+		for i := 0; i < 5; i++ {
+			tr.Go("Echo.Echo", EchoArgs{i, 10 * i}, &replies[i], 1, done)
+		}
+		for i := 0; i < 5; i++ {
+			call := <-done
+			if call.Ok == false || *(call.Reply.(*int)) != call.Args.(EchoArgs).Value {
+				t.Fatalf("Invalid asynchronous call (%+v)!", call)
+			}
+		}
+	}
+
+	call := end.Go("Echo.Echo", EchoArgs{3, 100}, nil, 1, nil)
+	net.DeleteServer(2)
+	if <-call.Done; call.Ok == true {
+		t.Fatal("Call to a deleted server should fail!")
+	}
+
+	// A completion that doesn't fit in the done channel isn't dropped silently
+	done := make(chan *Call, 1)
+	makeCall("Echo.Echo", EchoArgs{4, 0}, nil, 2, done).done(nil)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Completion on a full done channel was dropped!")
+		}
+	}()
+	makeCall("Echo.Echo", EchoArgs{5, 0}, nil, 2, done).done(nil)
+}
+
+func TestErrors(t *testing.T) {
+	fmt.Println("Test: Simulated Network and TCP Transport - Typed Errors")
+
+	net := MakeNetwork()
+	net.AddServer(2, makeEchoServer())
+	end := net.MakeEnd("1-2")
+	net.Connect("1-2", 2)
+	net.Enable("1-2", true)
+
+	l, err := ListenTCP("127.0.0.1:0", makeEchoServer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tcpEnd := MakeTCPEnd(l.Addr(), time.Second)
+	defer tcpEnd.Close()
+
+	for _, tr := range []Transport{end, tcpEnd} {
+		reply := 0
+		if err := tr.CallErr("Nope.Echo", EchoArgs{1, 0}, &reply, 1); errors.Is(err, ErrUnknownService) == false {
+			t.Fatalf("Expected an unknown service error (got %v)!", err)
+		}
+		if err := tr.CallErr("Echo.Nope", EchoArgs{1, 0}, &reply, 1); errors.Is(err, ErrUnknownMethod) == false {
+			t.Fatalf("Expected an unknown method error (got %v)!", err)
+		}
+		if err := tr.CallErr("Echo.Echo", "garbage", &reply, 1); errors.Is(err, ErrDecode) == false {
+			t.Fatalf("Expected a decode error for the arguments (got %v)!", err)
+		}
+		badReply := ""
+		if err := tr.CallErr("Echo.Echo", EchoArgs{1, 0}, &badReply, 1); errors.Is(err, ErrDecode) == false {
+			t.Fatalf("Expected a decode error for the reply (got %v)!", err)
+		}
+		if err := tr.CallErr("Echo.Echo", EchoArgs{4, 0}, &reply, 1); err != nil || reply != 4 {
+			t.Fatalf("The network should keep running after errors (err=%v, reply=%d)!", err, reply)
+		}
+	}
+
+	if n := net.ErrorCount(ErrUnknownService); n != 1 {
+		t.Fatalf("Invalid number of unknown service errors (%d)!", n)
+	}
+	if n := net.ErrorCount(ErrDecode); n != 2 { // Server-side arguments and client-side reply
+		t.Fatalf("Invalid number of decode errors (%d)!", n)
+	}
+
+	net.SetLinkEnabled(1, 2, false)
+	if err := end.CallErr("Echo.Echo", EchoArgs{1, 0}, nil, 1); err != ErrFailed {
+		t.Fatalf("Expected a failed call (got %v)!", err)
+	}
+}
@@ -0,0 +1,250 @@
+package network
+
+// Message trace recording and replay for the simulated network
+// A trace is a file with one JSON-encoded TraceEvent per line, recording every request and
+// reply the network handles (after interception) and what happened to it, so that a failing run
+// can be inspected message by message instead of through interleaved debug output
+//
+// net.StartTrace(path)   - Record every request and reply to the trace file at path
+// net.StopTrace()        - Stop recording and close the trace file
+// events, err := ReadTrace(path)
+// net.Replay(events)     - Feed the delivered requests of a trace into the network's servers
+//
+// => Replay() delivers requests directly to their destination servers (like Inject()) with the
+//    recorded spacing on the network's clock; replies are produced by the servers themselves
+// => Replay a prefix of a trace (i.e. events[:k]) to bisect the message that triggers a bug
+// => Payloads are gob-encoded, so a trace can only be replayed against servers with the same
+//    message types; Decoded is a best-effort JSON rendering for humans (or jq)
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DELIVERED   = "delivered"   // Message reached its destination
+	DROPPED     = "dropped"     // Message was lost (unreliable network, faults, links or interceptors)
+	UNREACHABLE = "unreachable" // Endpoint disabled or server deleted
+)
+
+type TraceEvent struct {
+	Time    time.Duration // Time since the trace started (on the network's clock)
+	Src     int           // Sending server (replying server for replies)
+	Dst     int           // Receiving server (calling server for replies)
+	SvcMeth string        // i.e. "XPaxos.Prepare"
+	IsReply bool
+	Size    int             // Size of the encoded payload (in bytes)
+	Outcome string          // DELIVERED, DROPPED or UNREACHABLE
+	Payload []byte          // Gob-encoded arguments (or reply)
+	Decoded json.RawMessage `json:",omitempty"`
+}
+
+type tracer struct {
+	mu    sync.Mutex
+	f     *os.File
+	w     *bufio.Writer
+	enc   *json.Encoder
+	start time.Time
+}
+
+func (rn *Network) StartTrace(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	tr := &tracer{}
+	tr.f = f
+	tr.w = bufio.NewWriter(f)
+	tr.enc = json.NewEncoder(tr.w)
+	tr.start = rn.clock.Now()
+
+	rn.mu.Lock()
+	old := rn.tracer
+	rn.tracer = tr
+	rn.mu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+func (rn *Network) StopTrace() error {
+	rn.mu.Lock()
+	tr := rn.tracer
+	rn.tracer = nil
+	rn.mu.Unlock()
+
+	if tr == nil {
+		return nil
+	}
+	return tr.close()
+}
+
+func ReadTrace(path string) ([]TraceEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []TraceEvent{}
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		ev := TraceEvent{}
+		if err := dec.Decode(&ev); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// Deliver every recorded request that reached its destination, preserving the recorded
+// spacing between requests; returns once the last request has been handed to its server
+func (rn *Network) Replay(events []TraceEvent) {
+	var last time.Duration
+	for _, ev := range events {
+		if ev.IsReply == true || ev.Outcome != DELIVERED {
+			continue
+		}
+		rn.clock.Sleep(ev.Time - last)
+		last = ev.Time
+
+		rn.mu.Lock()
+		server := rn.servers[ev.Dst]
+		rn.mu.Unlock()
+
+		if server != nil {
+			req := reqMsg{}
+			req.svcMeth = ev.SvcMeth
+			req.args = ev.Payload
+			req.callerId = ev.Src
+			go server.dispatch(req) // Arguments are decoded using the handler's signature
+		}
+	}
+}
+
+func (tr *tracer) close() error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if err := tr.w.Flush(); err != nil {
+		tr.f.Close()
+		return err
+	}
+	return tr.f.Close()
+}
+
+func (rn *Network) traceReq(req *reqMsg, servername interface{}, outcome string) {
+	rn.mu.Lock()
+	tr := rn.tracer
+	server := rn.servers[servername]
+	rn.mu.Unlock()
+
+	if tr == nil {
+		return
+	}
+
+	dst, ok := servername.(int)
+	if ok == false {
+		dst = ANY
+	}
+
+	argsType := req.argsType
+	if argsType == nil && server != nil {
+		argsType, _ = server.methodTypes(req.svcMeth)
+	}
+	tr.record(rn.clock, req.callerId, dst, req.svcMeth, false, outcome, req.args, argsType)
+}
+
+func (rn *Network) traceReply(req *reqMsg, servername interface{}, reply *replyMsg, outcome string) {
+	rn.mu.Lock()
+	tr := rn.tracer
+	server := rn.servers[servername]
+	rn.mu.Unlock()
+
+	if tr == nil {
+		return
+	}
+
+	src, ok := servername.(int)
+	if ok == false {
+		src = ANY
+	}
+
+	var replyType reflect.Type
+	if server != nil {
+		_, replyType = server.methodTypes(req.svcMeth)
+	}
+	tr.record(rn.clock, src, req.callerId, req.svcMeth, true, outcome, reply.reply, replyType)
+}
+
+func (tr *tracer) record(clock Clock, src int, dst int, svcMeth string, isReply bool, outcome string,
+	payload []byte, typ reflect.Type) {
+	ev := TraceEvent{}
+	ev.Time = clock.Now().Sub(tr.start)
+	ev.Src = src
+	ev.Dst = dst
+	ev.SvcMeth = svcMeth
+	ev.IsReply = isReply
+	ev.Size = len(payload)
+	ev.Outcome = outcome
+	ev.Payload = payload
+	ev.Decoded = decodePayload(payload, typ)
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if err := tr.enc.Encode(ev); err != nil {
+		dPrintf("Network: couldn't record trace event: %v\n", err)
+	}
+}
+
+// Render a gob-encoded payload as JSON (nil if its type is unknown or it can't be rendered)
+func decodePayload(payload []byte, typ reflect.Type) json.RawMessage {
+	if typ == nil || payload == nil {
+		return nil
+	}
+
+	v := reflect.New(typ)
+	rd := gob.NewDecoder(bytes.NewBuffer(payload))
+	if err := rd.Decode(v.Interface()); err != nil {
+		return nil
+	}
+
+	decoded, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// Argument and reply types of a service method's handler (nil if there is no such handler)
+func (rs *Server) methodTypes(svcMeth string) (reflect.Type, reflect.Type) {
+	dot := strings.LastIndex(svcMeth, ".")
+	if dot < 0 {
+		return nil, nil
+	}
+
+	rs.mu.Lock()
+	service, ok := rs.services[svcMeth[:dot]]
+	rs.mu.Unlock()
+	if ok == false {
+		return nil, nil
+	}
+
+	method, ok := service.methods[svcMeth[dot+1:]]
+	if ok == false {
+		return nil, nil
+	}
+	return method.Type.In(1), method.Type.In(2).Elem()
+}
@@ -0,0 +1,85 @@
+package network
+
+// Transport abstracts a client endpoint so that protocol code (XPaxos, PBFT, Paxos) does not
+// depend on a concrete network backend
+//
+// Implementations:
+// => *ClientEnd    - Channel-based simulator endpoint (see network.go)
+// => *TCPClientEnd - TCP endpoint (see tcp.go)
+//
+// tr.Call(svcMeth, args, &reply, callerId) - Send an RPC and wait for reply (false on failure)
+// tr.CallErr(svcMeth, args, &reply, callerId) - Like Call() but returns why it failed (see errors.go)
+// tr.CallWithTimeout(svcMeth, args, &reply, callerId, timeout)
+// => Like Call() but returns false if no reply arrives within timeout; reply is left untouched
+//    by replies that arrive late
+// tr.Go(svcMeth, args, &reply, callerId, done) - Send an RPC asynchronously and return its Call
+// => The Call is sent on done (a buffered channel, or nil to allocate one) when it completes, so
+//    a fan-out to many servers can share one channel instead of a goroutine per destination
+// => done must have room for every call on it that hasn't been received yet: a call completed on
+//    a full channel panics, rather than leaving whoever waits for it hanging
+// => Go() doesn't start a goroutine per call: the simulator completes the call from the goroutine
+//    processing its request, and a TCP endpoint from its connection's reader (or a timer)
+// tr.Send(svcMeth, args, callerId)         - Send an RPC asynchronously and discard the reply
+// tr.Peer()                                - Identity of the server at the other end
+
+import (
+	"reflect"
+	"time"
+)
+
+type Transport interface {
+	Call(svcMeth string, args interface{}, reply interface{}, callerId int) bool
+	CallErr(svcMeth string, args interface{}, reply interface{}, callerId int) error
+	CallWithTimeout(svcMeth string, args interface{}, reply interface{}, callerId int, timeout time.Duration) bool
+	Go(svcMeth string, args interface{}, reply interface{}, callerId int, done chan *Call) *Call
+	Send(svcMeth string, args interface{}, callerId int)
+	Peer() interface{}
+}
+
+// An asynchronous RPC (see Transport.Go)
+type Call struct {
+	SvcMeth string
+	Args    interface{}
+	Reply   interface{} // Valid once the call is done if Ok is true
+	Peer    interface{} // Identity of the server the call was sent to
+	Ok      bool
+	Err     error // Why the call failed (see errors.go)
+	Done    chan *Call
+}
+
+var _ Transport = (*ClientEnd)(nil)
+var _ Transport = (*TCPClientEnd)(nil)
+
+func makeCall(svcMeth string, args interface{}, reply interface{}, peer interface{}, done chan *Call) *Call {
+	if done == nil {
+		done = make(chan *Call, 1)
+	} else if cap(done) == 0 {
+		panic("network: Go() done channel is unbuffered")
+	}
+	return &Call{svcMeth, args, reply, peer, false, nil, done}
+}
+
+func (call *Call) done(err error) {
+	call.Ok = err == nil
+	call.Err = err
+	select {
+	case call.Done <- call:
+	default: // Never block the completing goroutine (i.e. a TCP connection's reader)
+		panic("network: Go() done channel is full (completion of " + call.SvcMeth + " would be lost)")
+	}
+}
+
+// Fresh value of the same type as reply (a pointer) to decode a reply into, so that a reply that
+// arrives after a timeout never writes to the caller's reply
+func newReply(reply interface{}) interface{} {
+	if reply == nil {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+}
+
+func copyReply(dst interface{}, src interface{}) {
+	if dst != nil {
+		reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src).Elem())
+	}
+}
@@ -21,6 +21,11 @@ package paxos
 // px.Max() int -- highest instance seq known, or -1
 // px.Min() int -- instances before this seq have been forgotten
 //
+// px = paxos.MakeTransport(peers []network.Transport, me int)
+// -- create a peer that sends RPCs over network.Transport (i.e. the simulator or
+//    TCP) instead of net/rpc over Unix sockets; the caller registers px with its
+//    own network.Server via network.MakeService(px)
+//
 
 import "net"
 import "net/rpc"
@@ -31,7 +36,9 @@ import "sync"
 //import "fmt"
 import "math"
 import "math/rand"
+import "network"
 import "sort"
+import "strconv"
 import "time"
 
 type Err string
@@ -44,7 +51,8 @@ type Paxos struct {
 	unreliable bool
 	rpcCount   int
 	peers      []string
-	me         int // index into peers[]
+	me         int                 // index into peers[]
+	transports []network.Transport // if non-nil, used instead of call()
 
 	// Your data here.
 	majority int //floor(npeers)/2 + 1
@@ -144,6 +152,22 @@ func call(srv string, name string, args interface{}, reply interface{}) bool {
 	return false
 }
 
+//
+// px.call() sends an RPC to peer dest using call() or, for peers
+// made with MakeTransport(), the peer's network.Transport.
+//
+func (px *Paxos) call(dest string, name string, args interface{}, reply interface{}) bool {
+	if px.transports == nil {
+		return call(dest, name, args, reply)
+	}
+	for i, peer := range px.peers {
+		if peer == dest {
+			return px.transports[i].Call(name, args, reply, px.me)
+		}
+	}
+	return false
+}
+
 //for debugging
 type Op struct {
 	// Your definitions here.
@@ -373,7 +397,7 @@ func (px *Paxos) sendPrepare(seq int, dest string, num int) (bool, *PrepareReply
 	}
 	px.mu.Unlock()
 	args := &PrepareArgs{seq, num, px.me, done}
-	ok := call(dest, "Paxos.RcvPrepare", args, &reply)
+	ok := px.call(dest, "Paxos.RcvPrepare", args, &reply)
 	return ok, &reply
 }
 
@@ -415,7 +439,7 @@ func (px *Paxos) sendAccept(seq int, dest string, n_a int, v_a interface{}) (boo
 	}
 	px.mu.Unlock()
 	args := &AcceptArgs{seq, n_a, v_a, px.me, done}
-	ok := call(dest, "Paxos.RcvAccept", args, &reply)
+	ok := px.call(dest, "Paxos.RcvAccept", args, &reply)
 	return ok, &reply
 }
 
@@ -452,7 +476,7 @@ func (px *Paxos) RcvAccept(args *AcceptArgs, reply *AcceptReply) error {
 
 func (px *Paxos) sendDecide(seq int, dest string, val interface{}) (bool, *DecideReply) {
 	var reply DecideReply
-	ok := call(dest, "Paxos.RcvDecide", &DecideArgs{seq, val}, &reply)
+	ok := px.call(dest, "Paxos.RcvDecide", &DecideArgs{seq, val}, &reply)
 	return ok, &reply
 }
 
@@ -685,3 +709,26 @@ func Make(peers []string, me int, rpcs *rpc.Server) *Paxos {
 	}
 	return px
 }
+
+//
+// the application wants to create a paxos peer that talks to
+// the other peers through peers[] (peers[me] is unused). RPCs
+// are not served by px itself: register network.MakeService(px)
+// with a network.Server instead.
+//
+func MakeTransport(peers []network.Transport, me int) *Paxos {
+	px := &Paxos{}
+	px.peers = make([]string, len(peers))
+	for i, _ := range peers {
+		px.peers[i] = strconv.Itoa(i)
+	}
+	px.me = me
+	px.transports = peers
+
+	px.instances = make(map[int]*PaxInst)
+	px.done = make(map[int]int)
+	px.majority = len(px.peers)/2 + 1
+	px.k = 0
+
+	return px
+}
@@ -119,7 +119,7 @@ func (client *Client) RePropose(op interface{}) bool {
 //
 // ------------------------------- MAKE FUNCTION ------------------------------
 //
-func MakeClient(replicas []*network.ClientEnd) *Client {
+func MakeClient(replicas []network.Transport) *Client {
 	client := &Client{}
 
 	client.mu.Lock()
@@ -41,7 +41,7 @@ type config struct {
 
 type Client struct {
 	mu        sync.Mutex
-	replicas  []*network.ClientEnd
+	replicas  []network.Transport
 	timestamp int
 	committed int
 	vcCh      chan bool
@@ -51,7 +51,7 @@ type Client struct {
 
 type Pbft struct {
 	mu               sync.Mutex
-	replicas         []*network.ClientEnd
+	replicas         []network.Transport
 	synchronousGroup map[int]bool
 	id               int
 	view             int
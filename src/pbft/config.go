@@ -86,7 +86,7 @@ func (cfg *config) start1(i int) {
 	}
 
 	// A fresh set of ClientEnds
-	ends := make([]*network.ClientEnd, cfg.n)
+	ends := make([]network.Transport, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
 		cfg.net.Connect(cfg.endnames[i][j], j)
@@ -139,7 +139,7 @@ func (cfg *config) startClient() {
 	}
 
 	// A fresh set of ClientEnds
-	ends := make([]*network.ClientEnd, cfg.n)
+	ends := make([]network.Transport, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[CLIENT][j])
 		cfg.net.Connect(cfg.endnames[CLIENT][j], j)
@@ -31,9 +31,10 @@ func (pbft *Pbft) Replicate(request ClientRequest, reply *Reply) {
 
 		prePrepareEntry := pbft.appendToPrepareLog(request, msg)
 		pbft.mu.Unlock()
+		done := make(chan *network.Call, len(pbft.synchronousGroup)) // Shared by the whole broadcast
 		for server, _ := range pbft.synchronousGroup {
 			if server != pbft.id {
-				go pbft.issuePrePrepare(server, prePrepareEntry)
+				pbft.sendPrePrepare(server, prePrepareEntry, done)
 			}
 		}
 		reply.Success = true
@@ -43,16 +44,11 @@ func (pbft *Pbft) Replicate(request ClientRequest, reply *Reply) {
 //
 // -------------------------------- PRE-PREPARE RPC -------------------------------
 //
-func (pbft *Pbft) sendPrePrepare(server int, prepareEntry PrepareLogEntry, reply *Reply) bool {
+// PBFT servers don't act on the replies to pre-prepare, prepare and commit messages, so nothing
+// reads done (it only has to have room for every call of the broadcast)
+func (pbft *Pbft) sendPrePrepare(server int, prepareEntry PrepareLogEntry, done chan *network.Call) *network.Call {
 	dPrintf("PrePrepare: from Pbft server (%d) to Pbft server (%d)\n", pbft.id, server)
-	return pbft.replicas[server].Call("Pbft.PrePrepare", prepareEntry, reply, pbft.id)
-}
-
-func (pbft *Pbft) issuePrePrepare(server int, prepareEntry PrepareLogEntry) {
-	reply := &Reply{}
-	if ok := pbft.sendPrePrepare(server, prepareEntry, reply); ok {
-
-	}
+	return pbft.replicas[server].Go("Pbft.PrePrepare", prepareEntry, &Reply{}, pbft.id, done)
 }
 
 func (pbft *Pbft) PrePrepare(prepareEntry PrepareLogEntry, reply *Reply) {
@@ -63,9 +59,10 @@ func (pbft *Pbft) PrePrepare(prepareEntry PrepareLogEntry, reply *Reply) {
 		if ok := pbft.addToPrepareLog(prepareEntry); !ok {
 			prepareEntry.Hop = pbft.id
 			pbft.mu.Unlock()
+			done := make(chan *network.Call, len(pbft.synchronousGroup))
 			for server, _ := range pbft.synchronousGroup {
 				if server != pbft.id {
-					go pbft.issuePrepare(server, prepareEntry)
+					pbft.sendPrepare(server, prepareEntry, done)
 				}
 			}
 			return
@@ -77,17 +74,9 @@ func (pbft *Pbft) PrePrepare(prepareEntry PrepareLogEntry, reply *Reply) {
 //
 // -------------------------------- PREPARE RPC -------------------------------
 //
-func (pbft *Pbft) sendPrepare(server int, prepareEntry PrepareLogEntry, reply *Reply) bool {
+func (pbft *Pbft) sendPrepare(server int, prepareEntry PrepareLogEntry, done chan *network.Call) *network.Call {
 	dPrintf("Prepare: from Pbft server (%d) to Pbft server (%d)\n", pbft.id, server)
-	return pbft.replicas[server].Call("Pbft.Prepare", prepareEntry, reply, pbft.id)
-}
-
-func (pbft *Pbft) issuePrepare(server int, prepareEntry PrepareLogEntry) {
-	reply := &Reply{}
-
-	if ok := pbft.sendPrepare(server, prepareEntry, reply); ok {
-
-	}
+	return pbft.replicas[server].Go("Pbft.Prepare", prepareEntry, &Reply{}, pbft.id, done)
 }
 
 func (pbft *Pbft) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
@@ -116,8 +105,9 @@ func (pbft *Pbft) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 
 				pbft.mu.Unlock()
 
+				done := make(chan *network.Call, len(pbft.synchronousGroup))
 				for server, _ := range pbft.synchronousGroup {
-					go pbft.issueCommit(server, cmsg)
+					pbft.sendCommit(server, cmsg, done)
 				}
 				return
 			}
@@ -129,17 +119,9 @@ func (pbft *Pbft) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 //
 // --------------------------------- COMMIT RPC --------------------------------
 //
-func (pbft *Pbft) sendCommit(server int, msg CommitMessage, reply *Reply) bool {
+func (pbft *Pbft) sendCommit(server int, msg CommitMessage, done chan *network.Call) *network.Call {
 	dPrintf("Commit: from Pbft server (%d) to Pbft server (%d) for SeqNum %d\n", pbft.id, server, msg.Msg.ClientTimestamp)
-	return pbft.replicas[server].Call("Pbft.Commit", msg, reply, pbft.id)
-}
-
-func (pbft *Pbft) issueCommit(server int, msg CommitMessage) {
-	reply := &Reply{}
-
-	if ok := pbft.sendCommit(server, msg, reply); ok {
-
-	}
+	return pbft.replicas[server].Go("Pbft.Commit", msg, &Reply{}, pbft.id, done)
 }
 
 func (pbft *Pbft) Commit(msg CommitMessage, reply *Reply) {
@@ -183,7 +165,7 @@ func (pbft *Pbft) issueReply(msg CommitMessage) {
 //
 // ------------------------------- MAKE FUNCTION ------------------------------
 //
-func Make(replicas []*network.ClientEnd, id int, privateKey *rsa.PrivateKey,
+func Make(replicas []network.Transport, id int, privateKey *rsa.PrivateKey,
 	publicKeys map[int]*rsa.PublicKey) *Pbft {
 	pbft := &Pbft{}
 
@@ -53,10 +53,12 @@ func benchmarkNoFaults(n int, size int, b *testing.B) {
 	op := make([]byte, size)
 	rand.Read(op) // Operation is random byte array of size bytes
 
+	cfg.net.ResetStats()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		cfg.client.Propose(op)
 	}
+	b.ReportMetric(float64(cfg.net.Stats().Total().Bytes)/float64(b.N), "bytes/op") // Bytes per commit
 }
 
 // Benchmark_3_0 - Number of PBFT servers = 4 (t=1), No Faults
@@ -0,0 +1,281 @@
+package xpaxos
+
+// Scripted Byzantine behaviours for XPaxos servers (test harness)
+// Each behaviour is a set of network interceptors (see network/intercept.go) that rewrite, drop
+// or replay the messages a server sends, so the protocol code stays honest and unaware of it. A
+// Byzantine server holds its own private key, so forged messages carry valid signatures
+//
+// cfg.setBehaviour(i, EQUIVOCATE) - Make XPaxos server i behave as below (HONEST to undo)
+// => SHUFFLE          - Reshuffle bytes in the signature of prepare and commit messages
+// => EQUIVOCATE       - As leader, send a different (validly signed) prepare to every follower
+// => TRUNCATE_LOG     - Drop the second half of the commit log in view-change messages
+// => FORGE_LOG        - Replace the second half of the commit log in view-change messages with
+//                       requests re-signed by the server itself in the new view
+// => REPLAY           - Replay every message of older views once a message of a newer view is sent
+// => WITHHOLD_NEWVIEW - As leader of a new view, never send new-view messages
+// => DROP_COMMITS     - Drop every other commit message
+// => TAMPER_REPLIES   - Flip the outcome of prepare and commit replies in transit, leaving their
+//                       signatures as they are (i.e. a faulty relay)
+//
+// XPaxos must either stay safe (i.e. commit logs agree) or detect each behaviour (i.e. by
+// changing view)
+
+import (
+	"crypto"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"network"
+	"sync"
+)
+
+type Behaviour int
+
+const (
+	HONEST           Behaviour = iota
+	SHUFFLE          Behaviour = iota
+	EQUIVOCATE       Behaviour = iota
+	TRUNCATE_LOG     Behaviour = iota
+	FORGE_LOG        Behaviour = iota
+	REPLAY           Behaviour = iota
+	WITHHOLD_NEWVIEW Behaviour = iota
+	DROP_COMMITS     Behaviour = iota
+	TAMPER_REPLIES   Behaviour = iota
+)
+
+var behaviourNames = []string{"honest", "shuffle", "equivocate", "truncate", "forge", "replay", "withhold",
+	"dropcommits", "tamper"}
+
+var viewMethods = []string{"XPaxos.Prepare", "XPaxos.Commit", "XPaxos.Suspect", "XPaxos.ViewChange",
+	"XPaxos.VCFinal", "XPaxos.NewView"}
+
+func (b Behaviour) String() string {
+	if int(b) < 0 || int(b) >= len(behaviourNames) {
+		return "unknown"
+	}
+	return behaviourNames[b]
+}
+
+// Replace the behaviour of XPaxos server i (removing the interceptors of its previous behaviour)
+func (cfg *config) setBehaviour(i int, behaviour Behaviour) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	for _, id := range cfg.byzantine[i] {
+		cfg.net.RemoveInterceptor(id)
+	}
+	delete(cfg.byzantine, i)
+
+	var ids []int
+	switch behaviour {
+	case SHUFFLE:
+		ids = cfg.shuffleSignatures(i)
+	case EQUIVOCATE:
+		ids = cfg.equivocate(i, cfg.privateKeys[i])
+	case TRUNCATE_LOG:
+		ids = cfg.alterCommitLogs(i, cfg.privateKeys[i], false)
+	case FORGE_LOG:
+		ids = cfg.alterCommitLogs(i, cfg.privateKeys[i], true)
+	case REPLAY:
+		ids = cfg.replayOldViews(i)
+	case WITHHOLD_NEWVIEW:
+		ids = cfg.dropMessages(i, "XPaxos.NewView", 1)
+	case DROP_COMMITS:
+		ids = cfg.dropMessages(i, "XPaxos.Commit", 2)
+	case TAMPER_REPLIES:
+		ids = cfg.tamperReplies(i)
+	}
+
+	if len(ids) > 0 {
+		cfg.byzantine[i] = ids
+	}
+}
+
+func (cfg *config) shuffleSignatures(i int) []int {
+	prepareFilter := network.Filter{SvcMeth: "XPaxos.Prepare", Src: i, Dst: network.ANY}
+	commitFilter := network.Filter{SvcMeth: "XPaxos.Commit", Src: i, Dst: network.ANY}
+
+	id1 := cfg.net.AddInterceptor(prepareFilter, func(m *network.Intercepted) {
+		prepareEntry := PrepareLogEntry{}
+		if m.Decode(&prepareEntry) == nil {
+			cfg.shuffle(prepareEntry.Msg0.Signature)
+			m.Encode(prepareEntry)
+		}
+	})
+	id2 := cfg.net.AddInterceptor(commitFilter, func(m *network.Intercepted) {
+		msg := Message{}
+		if m.Decode(&msg) == nil {
+			cfg.shuffle(msg.Signature)
+			m.Encode(msg)
+		}
+	})
+	return []int{id1, id2}
+}
+
+// Each follower gets a prepare for a request of its own, signed by the leader
+func (cfg *config) equivocate(i int, privateKey *rsa.PrivateKey) []int {
+	filter := network.Filter{SvcMeth: "XPaxos.Prepare", Src: i, Dst: network.ANY}
+
+	id := cfg.net.AddInterceptor(filter, func(m *network.Intercepted) {
+		prepareEntry := PrepareLogEntry{}
+		if m.Decode(&prepareEntry) == nil {
+			prepareEntry.Request.Operation = []byte{byte(m.Dst)}
+			prepareEntry.Msg0.MsgDigest = digest(prepareEntry.Request)
+			prepareEntry.Msg0.Signature = forgeSignature(privateKey, prepareDigest(prepareEntry.Msg0))
+			m.Encode(prepareEntry)
+		}
+	})
+	return []int{id}
+}
+
+// Truncate (or forge) the second half of the commit log in view-change messages
+func (cfg *config) alterCommitLogs(i int, privateKey *rsa.PrivateKey, forge bool) []int {
+	filter := network.Filter{SvcMeth: "XPaxos.ViewChange", Src: i, Dst: network.ANY}
+
+	id := cfg.net.AddInterceptor(filter, func(m *network.Intercepted) {
+		msg := ViewChangeMessage{}
+		if m.Decode(&msg) != nil {
+			return
+		}
+
+		half := len(msg.CommitLog) / 2
+		if forge == false {
+			msg.CommitLog = msg.CommitLog[:half]
+		} else {
+			for seqNum := half; seqNum < len(msg.CommitLog); seqNum++ {
+				commitEntry := &msg.CommitLog[seqNum]
+				commitEntry.Request.Operation = []byte("forged")
+				commitEntry.View = msg.View // Newer than any honest entry, so it would win the merge
+				commitEntry.Msg0.View = msg.View
+				commitEntry.Msg0.SenderId = i
+				commitEntry.Msg0.MsgDigest = digest(commitEntry.Request)
+				commitEntry.Msg0.Signature = forgeSignature(privateKey, prepareDigest(commitEntry.Msg0))
+			}
+		}
+		msg.MsgDigest = viewDigest(VIEWCHANGE, msg.View, msg.SenderId, commitLogDigest(msg.CommitLog))
+		msg.Signature = forgeSignature(privateKey, msg.MsgDigest) // Sign the altered message
+		m.Encode(msg)
+	})
+	return []int{id}
+}
+
+// Remember every message sent and, as soon as a message of a newer view is sent, deliver all
+// messages of older views again (once) to their original destinations
+func (cfg *config) replayOldViews(i int) []int {
+	type sentMessage struct {
+		svcMeth string
+		dst     int
+		args    interface{}
+		view    int
+	}
+
+	var mu sync.Mutex
+	sent := []sentMessage{}
+	lastView := 0
+
+	ids := []int{}
+	for _, svcMeth := range viewMethods {
+		filter := network.Filter{SvcMeth: svcMeth, Src: i, Dst: network.ANY}
+
+		id := cfg.net.AddInterceptor(filter, func(m *network.Intercepted) {
+			args, view, ok := decodeViewMessage(m)
+			if ok == false {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if view > lastView {
+				newer := []sentMessage{}
+				for _, old := range sent {
+					if old.view < view {
+						go cfg.net.Inject(i, old.dst, old.svcMeth, old.args, nil)
+					} else {
+						newer = append(newer, old)
+					}
+				}
+				sent = newer // Replay each message once, otherwise replays grow with every view
+				lastView = view
+			}
+			sent = append(sent, sentMessage{m.SvcMeth, m.Dst, args, view})
+		})
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Drop one in every "every" messages of svcMeth sent by XPaxos server i
+func (cfg *config) dropMessages(i int, svcMeth string, every int) []int {
+	filter := network.Filter{SvcMeth: svcMeth, Src: i, Dst: network.ANY}
+
+	var mu sync.Mutex
+	count := 0
+
+	id := cfg.net.AddInterceptor(filter, func(m *network.Intercepted) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		count++
+		if count%every == 0 {
+			m.Drop = true
+		}
+	})
+	return []int{id}
+}
+
+// Flip Success, IsLeader and Suspicious in the prepare and commit replies of XPaxos server i
+func (cfg *config) tamperReplies(i int) []int {
+	ids := []int{}
+	for _, svcMeth := range []string{"XPaxos.Prepare", "XPaxos.Commit"} {
+		filter := network.Filter{SvcMeth: svcMeth, Src: i, Dst: network.ANY, Replies: true}
+
+		id := cfg.net.AddInterceptor(filter, func(m *network.Intercepted) {
+			reply := Reply{}
+			if m.Decode(&reply) == nil {
+				reply.Success = !reply.Success
+				reply.IsLeader = !reply.IsLeader
+				reply.Suspicious = !reply.Suspicious
+				m.Encode(reply)
+			}
+		})
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Decode a protocol message and return it with the view it belongs to
+func decodeViewMessage(m *network.Intercepted) (interface{}, int, bool) {
+	switch m.SvcMeth {
+	case "XPaxos.Prepare":
+		prepareEntry := PrepareLogEntry{}
+		err := m.Decode(&prepareEntry)
+		return prepareEntry, prepareEntry.Msg0.View, err == nil
+	case "XPaxos.Commit":
+		msg := Message{}
+		err := m.Decode(&msg)
+		return msg, msg.View, err == nil
+	case "XPaxos.Suspect":
+		msg := SuspectMessage{}
+		err := m.Decode(&msg)
+		return msg, msg.View, err == nil
+	case "XPaxos.ViewChange":
+		msg := ViewChangeMessage{}
+		err := m.Decode(&msg)
+		return msg, msg.View, err == nil
+	case "XPaxos.VCFinal":
+		msg := VCFinalMessage{}
+		err := m.Decode(&msg)
+		return msg, msg.View, err == nil
+	case "XPaxos.NewView":
+		msg := NewViewMessage{}
+		err := m.Decode(&msg)
+		return msg, msg.View, err == nil
+	}
+	return nil, 0, false
+}
+
+func forgeSignature(privateKey *rsa.PrivateKey, msgDigest [32]byte) []byte {
+	signature, err := rsa.SignPKCS1v15(crand.Reader, privateKey, crypto.SHA256, msgDigest[:])
+	checkError(err)
+	return signature
+}
@@ -0,0 +1,252 @@
+package xpaxos
+
+// Commit certificates
+// A request is committed at a sequence number in view v once the leader of v prepared it and
+// every other member of v's synchronous group committed it. The commit log entry holding the
+// leader's signed prepare (Msg0) and the members' signed commits (Msg1) is the request's commit
+// certificate; view changes only adopt commit log entries whose certificate is complete and valid
+//
+// => Incomplete certificates (i.e. requests still being committed when the view changed) aren't
+//    adopted, but a member outside the view-change quorum may have committed their requests: the
+//    leader of the new view prepares them again before any other request, at the sequence numbers
+//    they had, so that they are committed in the new view with a complete certificate (see
+//    xp.pending)
+// => Invalid certificates (forged, altered or moved entries) are rejected, along with the rest of
+//    the commit log they were sent in, and the XPaxos server that sent them is reported (see
+//    xp.FaultReports())
+//
+// log := xp.ExportCertificates() - Committed log of the XPaxos server as self-contained commit
+//                                  certificates, with the public keys that check them
+// err := VerifyCertificates(log, trustedKeys) - Check every certificate of an exported log offline
+//                                               (i.e. for auditors, see cmd/xpverify)
+// => The number of XPaxos servers (and so the synchronous groups) is taken from the trusted keys,
+//    which must hold a key for every XPaxos server; the log's own keys and number of replicas must
+//    match them. With nil trusted keys, the log's own keys are trusted
+// => Exported logs are meant to be written as JSON (i.e. json.NewEncoder(w).Encode(log))
+// => The leader forwards the certificate of each request it executed to the client in its reply,
+//    as a commit proof (see client.go)
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"network"
+)
+
+type CertificateLog struct {
+	Replicas     int // Number of client and XPaxos servers (synchronous groups depend on it)
+	PublicKeys   map[int]*rsa.PublicKey
+	Certificates []Certificate
+}
+
+type Certificate struct {
+	SeqNum  int
+	View    int
+	Request json.RawMessage // Client request, JSON-encoded as XPaxos servers digest it
+	Prepare Message         // Leader's prepare message
+	Commits map[int]Message // Commit message of every other member of the synchronous group
+}
+
+type FaultReport struct {
+	ReplicaId int // XPaxos server that sent an invalid commit certificate
+	View      int // View of the view-change message it was sent in
+	SeqNum    int
+	Reason    string
+}
+
+// Check the commit certificate at seqNum of a commit log sent in a view-change message for view:
+// complete is false if a member's commit is missing, and reason says why the certificate is
+// invalid ("" if it isn't)
+func (xp *XPaxos) checkCommitCertificate(seqNum int, commitEntry CommitLogEntry, view int) (complete bool, reason string) {
+	return xp.checkCertificate(seqNum, digest(commitEntry.Request), commitEntry.View, commitEntry.Msg0,
+		commitEntry.Msg1, view)
+}
+
+func (xp *XPaxos) checkCertificate(seqNum int, msgDigest [32]byte, entryView int, msg0 Message,
+	msgMap map[int]Message, view int) (complete bool, reason string) {
+	if entryView != msg0.View || msg0.View >= view {
+		return false, fmt.Sprintf("prepare of view (%d) in view (%d)", msg0.View, view)
+	}
+	if msg0.MsgType != PREPARE || msg0.SenderId != xp.leaderOf(msg0.View) || msg0.PrepareSeqNum != seqNum+1 {
+		return false, fmt.Sprintf("prepare is not from the leader of view (%d) at this sequence number", msg0.View)
+	}
+	if msg0.MsgDigest != msgDigest || xp.verify(msg0.SenderId, prepareDigest(msg0), msg0.Signature) == false {
+		return false, "invalid prepare signature"
+	}
+
+	complete = true
+	for server, _ := range xp.groupOf(msg0.View) {
+		if server == msg0.SenderId {
+			continue
+		}
+
+		msg1, ok := msgMap[server]
+		if ok == false {
+			complete = false
+			continue
+		}
+
+		if msg1.MsgType != COMMIT || msg1.SenderId != server || msg1.View != msg0.View ||
+			msg1.PrepareSeqNum != seqNum+1 || msg1.MsgDigest != msgDigest ||
+			xp.verify(server, msg1.MsgDigest, msg1.Signature) == false {
+			return false, fmt.Sprintf("invalid commit from XPaxos server (%d)", server)
+		}
+	}
+	return complete, ""
+}
+
+// Longest prefix of a commit log (sent by senderId in a view-change message for view) whose
+// entries all carry a complete and valid commit certificate
+func (xp *XPaxos) certifiedPrefix(commitLog []CommitLogEntry, view int, senderId int) []CommitLogEntry {
+	certified, _ := xp.splitCommitLog(commitLog, view, senderId)
+	return certified
+}
+
+// Split a commit log (sent by senderId in a view-change message for view) into its certified prefix
+// and the entries after it whose certificate is valid but incomplete, up to the first invalid one
+func (xp *XPaxos) splitCommitLog(commitLog []CommitLogEntry, view int, senderId int) (certified []CommitLogEntry,
+	prepared []CommitLogEntry) {
+	certified = commitLog
+
+	for seqNum, commitEntry := range commitLog {
+		complete, reason := xp.checkCommitCertificate(seqNum, commitEntry, view)
+
+		if reason != "" {
+			xp.reportFault(senderId, view, seqNum, reason)
+			if len(certified) == len(commitLog) {
+				certified = commitLog[:seqNum]
+			}
+			return certified, commitLog[len(certified):seqNum]
+		}
+		if complete == false && len(certified) == len(commitLog) {
+			certified = commitLog[:seqNum]
+		}
+	}
+	return certified, commitLog[len(certified):]
+}
+
+func (xp *XPaxos) ExportCertificates() CertificateLog {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	log := CertificateLog{
+		Replicas:     len(xp.replicas),
+		PublicKeys:   make(map[int]*rsa.PublicKey, 0),
+		Certificates: make([]Certificate, 0)}
+
+	for server, publicKey := range xp.publicKeys {
+		if server != CLIENT {
+			log.PublicKeys[server] = publicKey
+		}
+	}
+
+	for seqNum := 0; seqNum < xp.executeSeqNum && seqNum < len(xp.commitLog); seqNum++ {
+		log.Certificates = append(log.Certificates, *xp.certificate(seqNum))
+	}
+	return log
+}
+
+// Commit certificate of an executed request (nil if the request at seqNum wasn't executed)
+func (xp *XPaxos) certificate(seqNum int) *Certificate {
+	if seqNum < 0 || seqNum >= xp.executeSeqNum || seqNum >= len(xp.commitLog) {
+		return nil
+	}
+
+	commitEntry := xp.commitLog[seqNum]
+	request, _ := json.Marshal(commitEntry.Request) // Requests are only prepared if they can be encoded
+
+	commits := make(map[int]Message, 0)
+	for server, msg := range commitEntry.Msg1 {
+		commits[server] = msg
+	}
+	return &Certificate{seqNum, commitEntry.View, request, commitEntry.Msg0, commits}
+}
+
+// XPaxos server that only checks signatures and computes synchronous groups (i.e. for clients and
+// auditors), given the number of client and XPaxos servers and their public keys
+func makeVerifier(replicas int, publicKeys map[int]*rsa.PublicKey) *XPaxos {
+	return &XPaxos{replicas: make([]network.Transport, replicas), publicKeys: publicKeys}
+}
+
+// Check a single commit certificate and return the digest of its request
+func (xp *XPaxos) verifyCertificate(certificate Certificate) ([32]byte, error) {
+	request := new(bytes.Buffer) // i.e. if the log was indented
+	if err := json.Compact(request, certificate.Request); err != nil {
+		return [32]byte{}, fmt.Errorf("invalid request: %v", err)
+	}
+
+	msgDigest := sha256.Sum256(request.Bytes())
+	complete, reason := xp.checkCertificate(certificate.SeqNum, msgDigest, certificate.View,
+		certificate.Prepare, certificate.Commits, certificate.View+1)
+	if reason != "" {
+		return msgDigest, fmt.Errorf("%s", reason)
+	}
+	if complete == false {
+		return msgDigest, fmt.Errorf("missing commit")
+	}
+	return msgDigest, nil
+}
+
+// Check that an exported log is a sequence of complete and valid commit certificates, numbered
+// from 0 without gaps, whose views never decrease, signed with the trusted keys (or the log's own
+// keys if trustedKeys is nil)
+func VerifyCertificates(log CertificateLog, trustedKeys map[int]*rsa.PublicKey) error {
+	if trustedKeys == nil {
+		trustedKeys = log.PublicKeys
+	}
+
+	replicas := len(trustedKeys) + 1 // i.e. every XPaxos server and the client
+	if replicas < 2 || replicas > MAXREPLICAS {
+		return fmt.Errorf("invalid number of trusted keys (%d)", len(trustedKeys))
+	}
+	if log.Replicas != replicas {
+		return fmt.Errorf("log has (%d) replicas, not (%d)", log.Replicas, replicas)
+	}
+	if len(log.PublicKeys) != len(trustedKeys) {
+		return fmt.Errorf("log has (%d) public keys, not (%d)", len(log.PublicKeys), len(trustedKeys))
+	}
+	for server := 1; server < replicas; server++ {
+		trustedKey, publicKey := trustedKeys[server], log.PublicKeys[server]
+		if trustedKey == nil {
+			return fmt.Errorf("missing trusted key of XPaxos server (%d)", server)
+		}
+		if publicKey == nil || publicKey.Equal(trustedKey) == false {
+			return fmt.Errorf("public key of XPaxos server (%d) is not trusted", server)
+		}
+	}
+
+	xp := makeVerifier(replicas, trustedKeys)
+	view := 0
+
+	for i, certificate := range log.Certificates {
+		if certificate.SeqNum != i {
+			return fmt.Errorf("certificate (%d) has sequence number (%d)", i, certificate.SeqNum)
+		}
+		if certificate.View < view {
+			return fmt.Errorf("certificate (%d) is of view (%d), after view (%d)", i, certificate.View, view)
+		}
+		view = certificate.View
+
+		if _, err := xp.verifyCertificate(certificate); err != nil {
+			return fmt.Errorf("certificate (%d): %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (xp *XPaxos) reportFault(senderId int, view int, seqNum int, reason string) {
+	iPrintf("VCFinal: invalid commit certificate (%d) from XPaxos server (%d): %s\n", seqNum, senderId, reason)
+	xp.faultReports = append(xp.faultReports, FaultReport{senderId, view, seqNum, reason})
+}
+
+// XPaxos servers caught sending invalid commit certificates (and why)
+func (xp *XPaxos) FaultReports() []FaultReport {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	faultReports := make([]FaultReport, len(xp.faultReports))
+	copy(faultReports, xp.faultReports)
+	return faultReports
+}
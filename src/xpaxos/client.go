@@ -2,10 +2,25 @@ package xpaxos
 
 // RPC handlers for an XPaxos client server (propose)
 //
-// client := MakeClient(replicas) - Creates an XPaxos client server
+// client := MakeClient(replicas, publicKeys) - Creates an XPaxos client server
 // => Option to perform cleanup with xp.Kill()
+// => Option to run timers on a virtual clock with client.SetClock(net.Clock())
+// => Option to record a history of proposals with client.SetHistory(MakeHistory(clock))
+//
+// Propose only reports success with a commit proof, checked against the XPaxos servers' public
+// keys: either the leader's signed reply carrying the request's commit certificate (see
+// certificate.go), or matching signed replies from every member (t+1) of a synchronous group
+// => After a view change, the new leader confirms the view to the client with a signed list of the
+//    timestamps of its committed requests (ConfirmVC): the client asks the new synchronous group for
+//    the result of each outstanding request that survived the view change, and resends the others
+//    to every XPaxos server
+// => Client requests aren't signed, so a faulty leader can commit another request in place of the
+//    client's (with the same timestamp); given a commit certificate for it, the client proposes its
+//    operation again with a new timestamp
 
 import (
+	"crypto/rsa"
+	"encoding/json"
 	"network"
 	"time"
 )
@@ -18,22 +33,29 @@ func (client *Client) sendReplicate(server int, request ClientRequest, reply *Re
 	return client.replicas[server].Call("XPaxos.Replicate", request, reply, CLIENT)
 }
 
-func (client *Client) issueReplicate(server int, request ClientRequest, replyCh chan bool, retry int) {
+func (client *Client) issueReplicate(server int, request ClientRequest, replyCh chan *Reply, doneCh chan bool, retry int) {
 	reply := &Reply{}
 
 	if ok := client.sendReplicate(server, request, reply); ok {
-		if reply.Success == true { // Only the leader should reply to client server
-			replyCh <- reply.Success
+		// Only signed replies for the request count towards a commit proof
+		if reply.Success == true && reply.MsgDigest == digest(request) && client.verifier.verifyReply(server, reply) == true {
+			select {
+			case replyCh <- reply:
+			case <-doneCh: // Propose already returned
+			}
 		}
 	} else {
 		if retry < RETRY {
 			retry++
-			go client.issueReplicate(server, request, replyCh, retry)
+			go client.issueReplicate(server, request, replyCh, doneCh, retry)
 		}
 	}
 }
 
-func (client *Client) Propose(op interface{}) { // For simplicity, we assume the client's proposal is correct
+// Returns the result of executing op (see kv.go); ok is false if the result is unknown (i.e. the
+// request timed out before the client got a commit proof)
+func (client *Client) Propose(op interface{}) (result interface{}, ok bool) {
+	// For simplicity, we assume the client's proposal is correct
 	var timer <-chan time.Time
 
 	client.mu.Lock()
@@ -43,48 +65,201 @@ func (client *Client) Propose(op interface{}) { // For simplicity, we assume the
 		Operation: op,
 		ClientId:  CLIENT}
 
-	replyCh := make(chan bool)
+	replyCh := make(chan *Reply)
+	doneCh := make(chan bool)
+	defer close(doneCh)
+	confirmCh := make(chan ConfirmVCMessage, 1)
+	client.outstanding[request.Timestamp] = confirmCh
+	defer client.complete(&request)
+	call := client.history.invoke(CLIENT, op)
+
+	client.broadcast(request, replyCh, doneCh)
+
+	if WAIT == false {
+		timer = client.clock.After(TIMEOUT * time.Millisecond)
+	}
+
+	client.timestamp++
+	client.mu.Unlock()
+
+	replies := make(map[[32]byte]map[int]bool, 0) // XPaxos servers that sent each matching reply
+
+	for ok == false {
+		select {
+		case <-timer:
+			iPrintf("Timeout: Client.Propose: client server (%d)\n", CLIENT)
+			client.history.respond(call, nil, false)
+			return nil, false
+		case reply := <-replyCh:
+			if reply.MsgDigest != digest(request) { // Reply to a superseded request
+				continue
+			}
+
+			if client.commitProof(reply, replies) == true {
+				iPrintf("Success: committed request (%d)\n", client.timestamp)
+				result, ok = reply.Result, true
+			} else if client.superseded(reply, request) == true {
+				iPrintf("Superseded: client server (%d) proposes request (%d) again\n", CLIENT, request.Timestamp)
+				client.mu.Lock()
+				delete(client.outstanding, request.Timestamp)
+				request.Timestamp = client.timestamp
+				client.outstanding[request.Timestamp] = confirmCh
+				client.timestamp++
+				client.mu.Unlock()
+
+				replies = make(map[[32]byte]map[int]bool, 0)
+				client.broadcast(request, replyCh, doneCh)
+			}
+		case msg := <-confirmCh:
+			if committed(msg.Timestamps, request.Timestamp) == true {
+				dPrintf("ConfirmVC: request (%d) survived view change (%d)\n", request.Timestamp, msg.View)
+				for server, _ := range client.verifier.groupOf(msg.View) {
+					go client.issueReplicate(server, request, replyCh, doneCh, 0)
+				}
+			} else {
+				dPrintf("ConfirmVC: client server (%d) resends request (%d)\n", CLIENT, request.Timestamp)
+				client.broadcast(request, replyCh, doneCh)
+			}
+		}
+	}
+
+	client.history.respond(call, result, ok)
+	return result, ok
+}
+
+func (client *Client) complete(request *ClientRequest) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
 
+	delete(client.outstanding, request.Timestamp)
+}
+
+func (client *Client) broadcast(request ClientRequest, replyCh chan *Reply, doneCh chan bool) {
 	for server, _ := range client.replicas {
 		if server != CLIENT {
-			go client.issueReplicate(server, request, replyCh, 0)
+			go client.issueReplicate(server, request, replyCh, doneCh, 0)
+		}
+	}
+}
+
+// A reply proves that the request committed if it carries the request's commit certificate (i.e.
+// from the leader), or once every member of the synchronous group of its view sent a matching reply.
+// Entries merged in a view change may not hold a complete certificate, so the leader's reply then
+// counts towards the synchronous group's replies like any other
+func (client *Client) commitProof(reply *Reply, replies map[[32]byte]map[int]bool) bool {
+	if reply.Certificate != nil {
+		msgDigest, err := client.verifier.verifyCertificate(*reply.Certificate)
+		if err == nil && msgDigest == reply.MsgDigest && reply.Certificate.SeqNum+1 == reply.SeqNum {
+			return true
 		}
 	}
 
-	if WAIT == false {
-		timer = time.NewTimer(TIMEOUT * time.Millisecond).C
+	group := client.verifier.groupOf(reply.View)
+	if group[reply.SenderId] == false || reply.SeqNum <= 0 { // i.e. the result is unknown
+		return false
 	}
 
-	client.timestamp++
-	client.mu.Unlock()
+	match := digest(struct {
+		View         int
+		SeqNum       int
+		ResultDigest [32]byte
+	}{reply.View, reply.SeqNum, resultDigest(reply.Result)})
 
-	select {
-	case <-timer:
-		iPrintf("Timeout: Client.Propose: client server (%d)\n", CLIENT)
-	case <-replyCh:
-		iPrintf("Success: committed request (%d)\n", client.timestamp)
-	case <-client.vcCh:
-		iPrintf("Success: committed request after view change (%d)", client.timestamp)
+	if replies[match] == nil {
+		replies[match] = make(map[int]bool, 0)
 	}
+	replies[match][reply.SenderId] = true
+	return len(replies[match]) == len(group)
 }
 
-func (client *Client) ConfirmVC(msg Message, reply *Reply) {
-	client.vcCh <- true
+// A commit certificate for another request with the same client ID and timestamp proves that the
+// request will never be committed
+func (client *Client) superseded(reply *Reply, request ClientRequest) bool {
+	if reply.Certificate == nil {
+		return false
+	}
+
+	msgDigest, err := client.verifier.verifyCertificate(*reply.Certificate)
+	if err != nil || msgDigest == reply.MsgDigest {
+		return false
+	}
+
+	certified := ClientRequest{}
+	if err := json.Unmarshal(reply.Certificate.Request, &certified); err != nil {
+		return false
+	}
+	return certified.ClientId == request.ClientId && certified.Timestamp == request.Timestamp
+}
+
+func committed(timestamps []int, timestamp int) bool {
+	for _, committedTimestamp := range timestamps {
+		if committedTimestamp == timestamp {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid confirmations are signed by the leader of their view
+func (client *Client) verifyConfirmVC(msg ConfirmVCMessage) bool {
+	msgDigest := viewDigest(CONFIRMVC, msg.View, msg.SenderId, timestampsDigest(msg.Timestamps))
+
+	return msg.MsgType == CONFIRMVC && msg.MsgDigest == msgDigest && msg.SenderId == client.verifier.leaderOf(msg.View) &&
+		client.verifier.verify(msg.SenderId, msgDigest, msg.Signature) == true
+}
+
+// Sent by the leader of a new view: outstanding requests (or their replies) may have been lost in
+// the view change
+func (client *Client) ConfirmVC(msg ConfirmVCMessage, reply *Reply) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if msg.View <= client.view || client.verifyConfirmVC(msg) == false {
+		return
+	}
+	client.view = msg.View
+
+	for _, confirmCh := range client.outstanding {
+		select { // Only the latest confirmation matters
+		case <-confirmCh:
+		default:
+		}
+		confirmCh <- msg
+	}
 }
 
 //
 // ------------------------------- MAKE FUNCTION ------------------------------
 //
-func MakeClient(replicas []*network.ClientEnd) *Client {
+func MakeClient(replicas []network.Transport, publicKeys map[int]*rsa.PublicKey) *Client {
 	client := &Client{}
 
 	client.mu.Lock()
 	client.replicas = replicas
+	client.verifier = makeVerifier(len(replicas), publicKeys)
 	client.timestamp = 0
-	client.vcCh = make(chan bool)
+	client.view = 0
+	client.outstanding = make(map[int]chan ConfirmVCMessage, 0)
+	client.clock = network.RealClock
 	client.mu.Unlock()
 
 	return client
 }
 
+// Run the client's timers on clock (i.e. a simulated network's virtual clock)
+func (client *Client) SetClock(clock network.Clock) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.clock = clock
+}
+
+// Record every proposal and its result to h
+func (client *Client) SetHistory(h *History) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.history = h
+}
+
 func (client *Client) Kill() {}
@@ -0,0 +1,81 @@
+package main
+
+// Offline verifier of XPaxos commit certificates
+// Checks a committed log exported with xp.ExportCertificates() (see xpaxos/certificate.go):
+// every prepare and commit signature, that every member of each synchronous group committed, and
+// that sequence numbers are contiguous - without running a cluster
+//
+// xpverify [-keys keys.json] log.json
+// => Exits with status 1 (and says why) if any certificate is invalid
+// => Requests are checked against their JSON encoding in the log, so the log may be indented but
+//    not re-encoded by other tools (i.e. that escape strings differently)
+// => The public keys are read from the log itself unless -keys names a JSON file of trusted keys
+//    (i.e. the cluster's published keys), in which case there must be a trusted key for every
+//    XPaxos server, the log's keys must match them and the log must have as many replicas (i.e. a
+//    log can't shrink the synchronous groups to the servers it forged signatures for)
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"xpaxos"
+)
+
+func main() {
+	keysFile := flag.String("keys", "", "JSON file of trusted public keys (by XPaxos server ID)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: xpverify [-keys keys.json] log.json\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var log xpaxos.CertificateLog
+	if err := readJSON(flag.Arg(0), &log); err != nil {
+		fail(err)
+	}
+
+	var trustedKeys map[int]*rsa.PublicKey // i.e. the log's own keys
+	if *keysFile != "" {
+		if err := readJSON(*keysFile, &trustedKeys); err != nil {
+			fail(err)
+		}
+		if trustedKeys == nil {
+			fail(fmt.Errorf("%s: no trusted keys", *keysFile))
+		}
+	}
+
+	if err := xpaxos.VerifyCertificates(log, trustedKeys); err != nil {
+		fail(err)
+	}
+
+	numCertificates := len(log.Certificates)
+	if numCertificates == 0 {
+		fmt.Println("OK: empty log")
+		return
+	}
+	fmt.Printf("OK: %d commit certificates (views %d to %d)\n", numCertificates, log.Certificates[0].View,
+		log.Certificates[numCertificates-1].View)
+}
+
+func readJSON(fileName string, v interface{}) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %v", fileName, err)
+	}
+	return nil
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "xpverify: %v\n", err)
+	os.Exit(1)
+}
@@ -2,18 +2,22 @@ package xpaxos
 
 import (
 	"crypto/rsa"
+	"math/rand"
 	"network"
 	"sync"
 	"testing"
 	"time"
 )
 
-const DEBUG = 1       // Debugging (0 = None, 1 = Info, 2 = Debug)
-const CLIENT = 0      // Client ID is always set to zero - DO NOT CHANGE
-const TIMEOUT = 10000 // Client timeout period (in milliseconds)
-const WAIT = true     // If false, client times out after TIMEOUT milliseconds; if true, client never times out
-const RETRY = 5       // Number of times the client tries to resend a failed replicate RPC
-const BITSIZE = 1024  // RSA private key bit size
+const DEBUG = 1          // Debugging (0 = None, 1 = Info, 2 = Debug)
+const CLIENT = 0         // Client ID is always set to zero - DO NOT CHANGE
+const TIMEOUT = 10000    // Client timeout period (in milliseconds)
+const WAIT = true        // If false, client times out after TIMEOUT milliseconds; if true, client never times out
+const RETRY = 5          // Number of times the client tries to resend a failed replicate RPC
+const BITSIZE = 1024     // RSA private key bit size
+const WINDOW = 1000      // Maximum gap between a client's consecutive timestamps
+const MAXOP = 1 << 26    // Maximum size of a client operation (JSON-encoded, in bytes)
+const MAXREPLICAS = 1024 // Maximum number of client and XPaxos servers of a verified certificate log
 
 const ( // RPC message types for common case and view change protocols
 	REPLICATE  = iota
@@ -24,33 +28,44 @@ const ( // RPC message types for common case and view change protocols
 	VIEWCHANGE = iota
 	VCFINAL    = iota
 	NEWVIEW    = iota
+	CONFIRMVC  = iota
 )
 
 type config struct {
 	mu          sync.Mutex
 	t           *testing.T
 	net         *network.Network
+	rand        *rand.Rand // Seeded from the network so that simulated runs make the same choices
 	n           int   // Total number of client and XPaxos servers
 	done        int32 // Tell internal threads to die
+	fuzzing     int32 // Collect safety violations instead of failing (see fuzz.go)
 	xpServers   []*XPaxos
 	client      *Client
 	connected   []bool     // Whether each server is on the net
 	endnames    [][]string // The port file names each sends to
 	privateKeys map[int]*rsa.PrivateKey
+	adminKey    *rsa.PrivateKey // Operator's key that signs re-admissions
 	publicKeys  map[int]*rsa.PublicKey
+	byzantine   map[int][]int // Network interceptor IDs for each Byzantine XPaxos server
+	history     *History      // Every proposal of the client (see linearizability.go)
+	monitor     *Monitor      // Safety monitor of every XPaxos server (see monitor.go)
 }
 
 type Client struct {
-	mu        sync.Mutex
-	replicas  []*network.ClientEnd
-	timestamp int
-	vcCh      chan bool
+	mu          sync.Mutex
+	replicas    []network.Transport
+	timestamp   int
+	view        int                           // Latest view confirmed by its leader
+	outstanding map[int]chan ConfirmVCMessage // View-change confirmations for each outstanding request (by timestamp)
+	clock       network.Clock
+	history     *History // Invocations and responses (see linearizability.go)
+	verifier    *XPaxos  // Checks replies against the XPaxos servers' public keys (see certificate.go)
 	// Must include statistics for evaluation
 }
 
 type XPaxos struct {
 	mu               sync.Mutex
-	replicas         []*network.ClientEnd
+	replicas         []network.Transport
 	synchronousGroup map[int]bool
 	id               int
 	view             int
@@ -68,7 +83,32 @@ type XPaxos struct {
 	vcTimer          <-chan time.Time
 	receivedVCFinal  map[int]map[[32]byte]ViewChangeMessage
 	vcInProgress     bool
-	byzantine        bool
+	clientTimestamps map[int]int // Latest timestamp in the prepare log for each client
+	stateMachine     StateMachine
+	applySeqNum      int                  // Number of commit log entries applied to the state machine
+	clientResults    map[int]clientResult // Result of the latest request applied for each client
+	monitor          *Monitor             // Safety monitor (see monitor.go)
+	faultReports     []FaultReport        // XPaxos servers that sent invalid commit certificates (see certificate.go)
+	proofs           []MisbehaviourProof  // Leaders caught sending conflicting prepares (see misbehaviour.go)
+	readmitted       map[int]int          // View in which each re-admitted replica was last re-admitted
+	readmitNonces    map[int]int          // Nonce of the last re-admission of each replica
+	adminKey         *rsa.PublicKey       // Operator's key that signs re-admissions (see misbehaviour.go)
+	pending          []ClientRequest      // Requests the new view prepares first (see certificate.go)
+	clock            network.Clock
+}
+
+// RPCs sent to several XPaxos servers at once, completed on one shared channel (see
+// network/transport.go) instead of a goroutine per destination
+type broadcast struct {
+	done  chan *network.Call
+	calls map[*network.Call]int // Destination of each call
+}
+
+type clientResult struct {
+	Timestamp int
+	MsgDigest [32]byte // Digest of the request applied with the timestamp
+	SeqNum    int
+	Result    interface{}
 }
 
 type PrepareLogEntry struct {
@@ -91,21 +131,27 @@ type ClientRequest struct {
 }
 
 type Message struct {
-	MsgType         int
-	MsgDigest       [32]byte
-	Signature       []byte
-	PrepareSeqNum   int
-	View            int
-	ClientTimestamp int
-	SenderId        int
+	MsgType          int
+	MsgDigest        [32]byte
+	Signature        []byte // Prepare messages are signed over prepareDigest, commit messages over MsgDigest
+	PrepareSeqNum    int
+	View             int
+	ClientTimestamp  int
+	SenderId         int
+	PrepareSignature []byte // Commit messages carry the leader's signature of the prepare they commit
 }
 
 type Reply struct {
-	MsgDigest  [32]byte
-	Signature  []byte
-	Success    bool
-	IsLeader   bool
-	Suspicious bool
+	MsgDigest   [32]byte
+	Signature   []byte // Signs every other field of the reply (see replyDigest)
+	SeqNum      int    // Sequence number of the prepare or commit replied to (0 if none)
+	View        int
+	SenderId    int
+	Success     bool
+	IsLeader    bool
+	Suspicious  bool
+	Result      interface{}  // Result of executing a client request (see kv.go)
+	Certificate *Certificate // Commit certificate of the request, in the leader's replies to the client
 }
 
 type SuspectMessage struct {
@@ -114,6 +160,7 @@ type SuspectMessage struct {
 	Signature []byte
 	View      int
 	SenderId  int
+	Proofs    []MisbehaviourProof // Self-certifying (see misbehaviour.go), so not signed
 }
 
 type ViewChangeMessage struct {
@@ -123,6 +170,7 @@ type ViewChangeMessage struct {
 	View      int
 	SenderId  int
 	CommitLog []CommitLogEntry
+	Proofs    []MisbehaviourProof // Self-certifying (see misbehaviour.go), so not signed
 }
 
 type VCFinalMessage struct {
@@ -142,3 +190,12 @@ type NewViewMessage struct {
 	PrepareLog []PrepareLogEntry
 	SenderId   int
 }
+
+type ConfirmVCMessage struct {
+	MsgType    int
+	MsgDigest  [32]byte
+	Signature  []byte
+	View       int
+	SenderId   int   // Leader of the new view
+	Timestamps []int // Timestamps of the client's requests in the new view's commit log
+}
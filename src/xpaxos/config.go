@@ -4,10 +4,16 @@ import (
 	crand "crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
+	"log"
 	"network"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func randstring(n int) string {
@@ -17,11 +23,32 @@ func randstring(n int) string {
 	return s[0:n]
 }
 
+// Set XPAXOS_SIM=1 to run tests on a simulated network with a fresh seed, or XPAXOS_SEED=<seed>
+// to rerun a simulated run with the same network behaviour (the seed of a simulated run is logged
+// when a test fails; see network/clock.go for what a seed doesn't reproduce)
+// Set XPAXOS_TRACE=<dir> to record a message trace of each test to <dir>/<test>.jsonl
 func makeConfig(t *testing.T, n int, unreliable bool) *config {
+	if seed, err := strconv.ParseInt(os.Getenv("XPAXOS_SEED"), 10, 64); err == nil {
+		return makeSimConfig(t, n, unreliable, seed)
+	} else if os.Getenv("XPAXOS_SIM") != "" {
+		return makeSimConfig(t, n, unreliable, time.Now().UnixNano())
+	}
+	return makeConfigNetwork(t, n, unreliable, network.MakeNetwork())
+}
+
+func makeSimConfig(t *testing.T, n int, unreliable bool, seed int64) *config {
+	if t != nil {
+		t.Logf("Simulated network (XPAXOS_SEED=%d)", seed)
+	}
+	return makeConfigNetwork(t, n, unreliable, network.MakeSimNetwork(seed))
+}
+
+func makeConfigNetwork(t *testing.T, n int, unreliable bool, net *network.Network) *config {
 	runtime.GOMAXPROCS(4)
 	cfg := &config{}
 	cfg.t = t
-	cfg.net = network.MakeNetwork()
+	cfg.net = net
+	cfg.rand = network.MakeRand(net.Seed())
 	cfg.n = n
 	cfg.xpServers = make([]*XPaxos, cfg.n)
 	cfg.client = &Client{}
@@ -29,10 +56,22 @@ func makeConfig(t *testing.T, n int, unreliable bool) *config {
 	cfg.endnames = make([][]string, cfg.n)
 	cfg.privateKeys = make(map[int]*rsa.PrivateKey, cfg.n)
 	cfg.publicKeys = make(map[int]*rsa.PublicKey, cfg.n)
+	cfg.adminKey, _ = generateKeys()
+	cfg.byzantine = make(map[int][]int, 0)
+	cfg.history = MakeHistory(net.Clock())
+	cfg.monitor = MakeMonitor(cfg.reportViolation)
 
 	cfg.setUnreliable(unreliable)
 	cfg.net.LongDelays(false)
 
+	if dir := os.Getenv("XPAXOS_TRACE"); dir != "" && t != nil {
+		path := filepath.Join(dir, strings.Replace(t.Name(), "/", "_", -1)+".jsonl")
+		if err := cfg.net.StartTrace(path); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("Message trace (%s)", path)
+	}
+
 	cfg.startClient() // Create client server
 
 	for i := 1; i < cfg.n; i++ { // Create a full set of XPaxos servers
@@ -86,7 +125,7 @@ func (cfg *config) start1(i int) {
 	}
 
 	// A fresh set of ClientEnds
-	ends := make([]*network.ClientEnd, cfg.n)
+	ends := make([]network.Transport, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
 		cfg.net.Connect(cfg.endnames[i][j], j)
@@ -98,6 +137,10 @@ func (cfg *config) start1(i int) {
 	cfg.publicKeys[i] = publicKey
 
 	xp := Make(ends, i, cfg.privateKeys[i], cfg.publicKeys)
+	xp.SetClock(cfg.net.Clock())
+	xp.SetStateMachine(MakeKVStore())
+	xp.SetMonitor(cfg.monitor)
+	xp.SetAdminKey(&cfg.adminKey.PublicKey)
 
 	cfg.mu.Lock()
 	cfg.xpServers[i] = xp
@@ -139,13 +182,15 @@ func (cfg *config) startClient() {
 	}
 
 	// A fresh set of ClientEnds
-	ends := make([]*network.ClientEnd, cfg.n)
+	ends := make([]network.Transport, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[CLIENT][j])
 		cfg.net.Connect(cfg.endnames[CLIENT][j], j)
 	}
 
-	client := MakeClient(ends)
+	client := MakeClient(ends, cfg.publicKeys)
+	client.SetClock(cfg.net.Clock())
+	client.SetHistory(cfg.history)
 
 	cfg.mu.Lock()
 	cfg.client = client
@@ -158,6 +203,7 @@ func (cfg *config) startClient() {
 }
 
 func (cfg *config) cleanup() {
+	cfg.monitor.stop() // Crashed XPaxos servers may outlive the test
 	if cfg.client != nil {
 		cfg.client.Kill()
 	}
@@ -169,6 +215,8 @@ func (cfg *config) cleanup() {
 	}
 
 	atomic.StoreInt32(&cfg.done, 1)
+	cfg.net.StopTrace()
+	cfg.net.Cleanup()
 }
 
 // Connect server i to the network
@@ -236,3 +284,38 @@ func (cfg *config) setUnreliable(unrel bool) {
 func (cfg *config) setLongReordering(longrel bool) {
 	cfg.net.LongReordering(longrel)
 }
+
+// Make XPaxos server i (non-)Byzantine: a Byzantine server reshuffles bytes in the signature of
+// the prepare and commit messages it sends (see byzantine.go for other behaviours)
+func (cfg *config) setByzantine(i int, byzantine bool) {
+	if byzantine == true {
+		cfg.setBehaviour(i, SHUFFLE)
+	} else {
+		cfg.setBehaviour(i, HONEST)
+	}
+}
+
+// Fail the test (or benchmark) on a safety violation reported by the monitor (see monitor.go)
+func (cfg *config) reportViolation(violation string) {
+	if atomic.LoadInt32(&cfg.fuzzing) == 1 { // Fuzz runs check cfg.monitor.Violations() (see fuzz.go)
+		return
+	}
+
+	if cfg.t != nil {
+		cfg.t.Errorf("Safety violation: %s", violation)
+	} else {
+		log.Fatalf("Safety violation: %s", violation) // Benchmarks
+	}
+}
+
+// Fault schedule (see network/schedule.go) whose Byzantine events use setByzantine()
+func (cfg *config) makeSchedule() *network.Schedule {
+	return cfg.net.MakeSchedule().OnByzantine(cfg.setByzantine)
+}
+
+func (cfg *config) shuffle(b []byte) {
+	for i := len(b) - 1; i > 0; i-- {
+		j := cfg.rand.Intn(i + 1)
+		b[i], b[j] = b[j], b[i]
+	}
+}
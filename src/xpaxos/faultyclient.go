@@ -0,0 +1,90 @@
+package xpaxos
+
+// Scripted Byzantine clients (test harness)
+// A faulty client injects replicate requests straight into the XPaxos servers (see
+// network/intercept.go) and ignores their replies; correct clients must keep making progress
+//
+// fc := cfg.makeFaultyClient(id, EQUIVOCATING_CLIENT) - Faulty client using ClientId id
+// fc.propose()                                        - Send one round of faulty requests (and
+//                                                       wait until every XPaxos server handled it)
+// => EQUIVOCATING_CLIENT  - Send a different request with the same timestamp to every XPaxos
+//                           server
+// => FLOODING_CLIENT      - Send requests with huge timestamps, both as itself and impersonating
+//                           the correct client (CLIENT)
+// => MALFORMED_CLIENT     - Send requests with an operation that has no digest (NaN), the wrong
+//                           message type or a negative timestamp
+// => IMPERSONATING_CLIENT - Send requests as the correct client, each with a timestamp WINDOW
+//                           ahead of the previous one, so that the correct client's own requests
+//                           look like duplicates (client IDs aren't authenticated, see
+//                           validRequest())
+
+import (
+	"math"
+	"sync"
+)
+
+type ClientBehaviour int
+
+const (
+	EQUIVOCATING_CLIENT  ClientBehaviour = iota
+	FLOODING_CLIENT      ClientBehaviour = iota
+	MALFORMED_CLIENT     ClientBehaviour = iota
+	IMPERSONATING_CLIENT ClientBehaviour = iota
+)
+
+type faultyClient struct {
+	cfg       *config
+	id        int
+	behaviour ClientBehaviour
+	timestamp int
+}
+
+func (cfg *config) makeFaultyClient(id int, behaviour ClientBehaviour) *faultyClient {
+	fc := &faultyClient{}
+	fc.cfg = cfg
+	fc.id = id
+	fc.behaviour = behaviour
+	fc.timestamp = 0
+	return fc
+}
+
+func (fc *faultyClient) propose() {
+	var wg sync.WaitGroup
+
+	for server := 1; server < fc.cfg.n; server++ {
+		request := ClientRequest{
+			MsgType:   REPLICATE,
+			Timestamp: fc.timestamp,
+			Operation: []byte{byte(server)}, // Different request for every XPaxos server
+			ClientId:  fc.id}
+
+		switch fc.behaviour {
+		case FLOODING_CLIENT:
+			request.Timestamp = math.MaxInt64 - fc.timestamp
+			if server%2 == 0 {
+				request.ClientId = CLIENT
+			}
+		case IMPERSONATING_CLIENT:
+			request.Timestamp = (fc.timestamp+1)*WINDOW - 1
+			request.ClientId = CLIENT
+		case MALFORMED_CLIENT:
+			switch fc.timestamp % 3 {
+			case 0:
+				request.Operation = math.NaN()
+			case 1:
+				request.MsgType = COMMIT
+			case 2:
+				request.Timestamp = -1
+			}
+		}
+
+		wg.Add(1)
+		go func(server int, request ClientRequest) {
+			defer wg.Done()
+			fc.cfg.net.Inject(CLIENT, server, "XPaxos.Replicate", request, &Reply{})
+		}(server, request)
+	}
+	wg.Wait()
+
+	fc.timestamp++
+}
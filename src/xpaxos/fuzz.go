@@ -0,0 +1,373 @@
+package xpaxos
+
+// Randomized fault schedules (test harness)
+// A fuzz run drives a key-value workload through a simulated network while crashes, partitions,
+// link failures, delays and Byzantine behaviours come and go, then checks the safety monitor
+// (see monitor.go) and the linearizability of the client's history (see linearizability.go).
+// Faults stay within the XFT budget: at any time at most t XPaxos servers are crashed,
+// partitioned, slow, Byzantine or convicted (and each of them has a single fault)
+//
+// fs := randomFuzzSchedule(r, servers, ops)  - Random schedule for a run of ops client requests
+// failure := runFuzzSchedule(fs)             - Run it on a simulated network ("" if it passed)
+// fs = shrinkFuzzSchedule(fs, fails)         - Smallest schedule (fewer faults, shorter faults, fewer
+//                                              operations) for which fails() still holds
+// fs, err := parseFuzzSchedule(fs.String())  - Replay a schedule (i.e. XPAXOS_FUZZ_SCHEDULE)
+//
+// Schedules are written as "seed=42 servers=4 ops=20; #2-#6 crash 1; #3-#8 isolate 2;
+// #4-#9 link 1 3; #5-#7 delay 3; #1-#9 byzantine 2 equivocate", where a fault is injected once
+// the workload completed as many operations as its first count and removed at its second count
+// => A run that makes no progress for FUZZTIMEOUT (simulated time) and FUZZREALTIMEOUT (real time)
+//    also fails; the virtual clock may run ahead of servers that are busy signing on a loaded machine
+
+import (
+	"fmt"
+	"math/rand"
+	"network"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FUZZTIMEOUT     = 60 * time.Second // Maximum simulated time without progress
+	FUZZREALTIMEOUT = 3 * time.Second  // Maximum real time without progress
+	FUZZRUNS        = 4                // Schedules run by TestFuzz by default
+	FUZZSEED        = 1                // Seed of the default schedules (the same ones on every run)
+)
+
+const (
+	FUZZ_CRASH     = "crash"     // Server fails to send/receive every RPC
+	FUZZ_ISOLATE   = "isolate"   // Server is partitioned from every other XPaxos server
+	FUZZ_LINK      = "link"      // Links between server and peer are down
+	FUZZ_DELAY     = "delay"     // Every link of server is slower than the synchronous group's timeouts
+	FUZZ_BYZANTINE = "byzantine" // Server behaves as in byzantine.go
+)
+
+var fuzzKinds = []string{FUZZ_CRASH, FUZZ_ISOLATE, FUZZ_LINK, FUZZ_DELAY, FUZZ_BYZANTINE}
+
+type fuzzFault struct {
+	Start     int // Injected once Start operations completed
+	End       int // Removed once End operations completed
+	Kind      string
+	Server    int
+	Peer      int       // Other end of a FUZZ_LINK fault
+	Behaviour Behaviour // Behaviour of a FUZZ_BYZANTINE fault
+}
+
+type fuzzSchedule struct {
+	Seed    int64 // Seed of the simulated network
+	Servers int   // Total number of client and XPaxos servers
+	Ops     int   // Number of client requests
+	Faults  []fuzzFault
+}
+
+func randomFuzzSchedule(r *rand.Rand, servers int, ops int) fuzzSchedule {
+	fs := fuzzSchedule{Seed: r.Int63(), Servers: servers, Ops: ops, Faults: []fuzzFault{}}
+
+	numFaults := r.Intn(2*fs.budget()+2) + 1
+	for attempt := 0; attempt < 100 && len(fs.Faults) < numFaults; attempt++ {
+		start := r.Intn(ops)
+		f := fuzzFault{
+			Start:  start,
+			End:    start + 1 + r.Intn(ops-start),
+			Kind:   fuzzKinds[r.Intn(len(fuzzKinds))],
+			Server: r.Intn(servers-1) + 1}
+
+		switch f.Kind {
+		case FUZZ_LINK:
+			f.Peer = r.Intn(servers-1) + 1
+			if f.Peer == f.Server {
+				continue
+			}
+		case FUZZ_BYZANTINE:
+			f.Behaviour = Behaviour(r.Intn(len(behaviourNames)-1) + 1) // Anything but HONEST
+		}
+
+		if fs.withinBudget(f) == true {
+			fs.Faults = append(fs.Faults, f)
+		}
+	}
+	return fs
+}
+
+// Maximum number of faulty XPaxos servers at any time (t)
+func (fs fuzzSchedule) budget() int {
+	return (fs.Servers - 2) / 2
+}
+
+// Whether f can be added without more than t faulty XPaxos servers at any time
+func (fs fuzzSchedule) withinBudget(f fuzzFault) bool {
+	for op := f.Start; op < fs.end(f); op++ {
+		faulty := map[int]bool{f.Server: true}
+		for _, g := range fs.Faults {
+			if g.Start <= op && op < fs.end(g) {
+				if g.Server == f.Server {
+					return false
+				}
+				faulty[g.Server] = true
+			}
+		}
+		if len(faulty) > fs.budget() {
+			return false
+		}
+	}
+	return true
+}
+
+// Operation count until which a fault keeps its server out of synchronous groups: an equivocating
+// server stays convicted for the rest of the run (see misbehaviour.go)
+func (fs fuzzSchedule) end(f fuzzFault) int {
+	if f.Kind == FUZZ_BYZANTINE && f.Behaviour == EQUIVOCATE {
+		return fs.Ops
+	}
+	return f.End
+}
+
+// Run the workload under the schedule's faults and return why it failed ("" if it didn't)
+func runFuzzSchedule(fs fuzzSchedule) string {
+	cfg := makeSimConfig(nil, fs.Servers, false, fs.Seed)
+	atomic.StoreInt32(&cfg.fuzzing, 1)
+	defer cfg.cleanup()
+
+	s := cfg.makeSchedule()
+	for i, f := range fs.Faults {
+		name := fmt.Sprintf("fuzz-%d", i) // Partition name
+		s.AfterOps(f.Start).Do(f.String(), func(f fuzzFault) func() {
+			return func() { cfg.setFuzzFault(f, name, true) }
+		}(f))
+		s.AfterOps(f.End).Do("end "+f.String(), func(f fuzzFault) func() {
+			return func() { cfg.setFuzzFault(f, name, false) }
+		}(f))
+	}
+	s.Start()
+	defer s.Stop()
+
+	done := make(chan bool, 1)
+	go func() {
+		for i := 0; i < fs.Ops; i++ {
+			key := strconv.Itoa(i % 3)
+			if i%2 == 0 {
+				cfg.client.Propose(KVOp{APPEND, key, strconv.Itoa(i)})
+			} else {
+				cfg.client.Propose(KVOp{GET, key, ""})
+			}
+			s.Op()
+		}
+		done <- true
+	}()
+
+	ops, progress := 0, time.Now() // Operations completed so far and when the last one completed
+	for finished := false; finished == false; {
+		select {
+		case <-done:
+			finished = true
+		case <-cfg.net.Clock().After(FUZZTIMEOUT):
+			if s.Ops() != ops {
+				ops, progress = s.Ops(), time.Now()
+			} else if time.Since(progress) >= FUZZREALTIMEOUT {
+				return fmt.Sprintf("no progress after %d of %d operations", ops, fs.Ops)
+			}
+		}
+	}
+
+	if violations := cfg.monitor.Violations(); len(violations) > 0 {
+		return "safety violation: " + violations[0]
+	}
+	if CheckLinearizable(KVModel, cfg.history.Entries()) == false {
+		return "client history is not linearizable"
+	}
+	return ""
+}
+
+// Inject (or remove) a fault
+func (cfg *config) setFuzzFault(f fuzzFault, name string, inject bool) {
+	switch f.Kind {
+	case FUZZ_CRASH:
+		if inject == true {
+			cfg.net.SetFaultRate(f.Server, 100)
+		} else {
+			cfg.net.SetFaultRate(f.Server, 0)
+		}
+	case FUZZ_ISOLATE:
+		if inject == true {
+			others := []int{}
+			for i := 1; i < cfg.n; i++ {
+				if i != f.Server {
+					others = append(others, i)
+				}
+			}
+			cfg.net.Partition(name, []int{f.Server}, others)
+		} else {
+			cfg.net.Heal(name)
+		}
+	case FUZZ_LINK:
+		cfg.net.SetLinkEnabled(f.Server, f.Peer, !inject)
+		cfg.net.SetLinkEnabled(f.Peer, f.Server, !inject)
+	case FUZZ_DELAY:
+		var latency network.Latency // Default (no latency)
+		if inject == true {
+			latency = network.ConstantLatency{D: 2 * network.DELTA * time.Millisecond}
+		}
+		for i := 0; i < cfg.n; i++ {
+			if i != f.Server {
+				cfg.net.SetLinkLatency(f.Server, i, latency)
+				cfg.net.SetLinkLatency(i, f.Server, latency)
+			}
+		}
+	case FUZZ_BYZANTINE:
+		if inject == true {
+			cfg.setBehaviour(f.Server, f.Behaviour)
+		} else {
+			cfg.setBehaviour(f.Server, HONEST)
+		}
+	}
+}
+
+// Shrink a failing schedule greedily: keep the first smaller schedule that still fails (drop a
+// fault, cut the workload after the last fault or in half, or halve a fault) until none does
+func shrinkFuzzSchedule(fs fuzzSchedule, fails func(fuzzSchedule) bool) fuzzSchedule {
+	for shrunk := true; shrunk == true; {
+		shrunk = false
+		for _, candidate := range fs.shrinkCandidates() {
+			if fails(candidate) == true {
+				fs = candidate
+				shrunk = true
+				break
+			}
+		}
+	}
+	return fs
+}
+
+// Schedules one step smaller than fs (every candidate has fewer faults, fewer operations or
+// shorter faults, so shrinking terminates)
+func (fs fuzzSchedule) shrinkCandidates() []fuzzSchedule {
+	candidates := []fuzzSchedule{}
+
+	for i := range fs.Faults {
+		candidate := fs.copy()
+		candidate.Faults = append(candidate.Faults[:i], candidate.Faults[i+1:]...)
+		candidates = append(candidates, candidate)
+	}
+
+	last := 1 // Operations after the last fault ends
+	for _, f := range fs.Faults {
+		if f.End > last {
+			last = f.End
+		}
+	}
+	if last < fs.Ops {
+		candidate := fs.copy()
+		candidate.Ops = last
+		candidates = append(candidates, candidate)
+	}
+
+	if fs.Ops > 1 {
+		candidate := fs.copy()
+		candidate.Ops = fs.Ops / 2
+		candidate.Faults = []fuzzFault{}
+		for _, f := range fs.Faults {
+			if f.Start < candidate.Ops {
+				if f.End > candidate.Ops {
+					f.End = candidate.Ops
+				}
+				candidate.Faults = append(candidate.Faults, f)
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	for i, f := range fs.Faults {
+		if f.End-f.Start > 1 {
+			half := (f.End - f.Start) / 2
+			early := fs.copy()
+			early.Faults[i].End = f.End - half
+			late := fs.copy()
+			late.Faults[i].Start = f.Start + half
+			candidates = append(candidates, early, late)
+		}
+	}
+	return candidates
+}
+
+func (fs fuzzSchedule) copy() fuzzSchedule {
+	faults := make([]fuzzFault, len(fs.Faults))
+	copy(faults, fs.Faults)
+	fs.Faults = faults
+	return fs
+}
+
+func (fs fuzzSchedule) String() string {
+	s := fmt.Sprintf("seed=%d servers=%d ops=%d", fs.Seed, fs.Servers, fs.Ops)
+	for _, f := range fs.Faults {
+		s += fmt.Sprintf("; #%d-#%d %s", f.Start, f.End, f)
+	}
+	return s
+}
+
+func (f fuzzFault) String() string {
+	switch f.Kind {
+	case FUZZ_LINK:
+		return fmt.Sprintf("%s %d %d", f.Kind, f.Server, f.Peer)
+	case FUZZ_BYZANTINE:
+		return fmt.Sprintf("%s %d %v", f.Kind, f.Server, f.Behaviour)
+	}
+	return fmt.Sprintf("%s %d", f.Kind, f.Server)
+}
+
+func parseFuzzSchedule(text string) (fuzzSchedule, error) {
+	fs := fuzzSchedule{Faults: []fuzzFault{}}
+
+	parts := strings.Split(text, ";")
+	if _, err := fmt.Sscanf(strings.TrimSpace(parts[0]), "seed=%d servers=%d ops=%d", &fs.Seed, &fs.Servers,
+		&fs.Ops); err != nil {
+		return fs, fmt.Errorf("invalid fuzz schedule %q: %v", parts[0], err)
+	}
+
+	for _, part := range parts[1:] {
+		f, err := parseFuzzFault(strings.Fields(part))
+		if err != nil {
+			return fs, fmt.Errorf("invalid fuzz fault %q: %v", part, err)
+		}
+		fs.Faults = append(fs.Faults, f)
+	}
+	return fs, nil
+}
+
+func parseFuzzFault(fields []string) (fuzzFault, error) {
+	f := fuzzFault{}
+	if len(fields) < 3 {
+		return f, fmt.Errorf("too few fields")
+	}
+	if _, err := fmt.Sscanf(fields[0], "#%d-#%d", &f.Start, &f.End); err != nil {
+		return f, err
+	}
+
+	f.Kind = fields[1]
+	server, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return f, err
+	}
+	f.Server = server
+
+	switch {
+	case (f.Kind == FUZZ_CRASH || f.Kind == FUZZ_ISOLATE || f.Kind == FUZZ_DELAY) && len(fields) == 3:
+	case f.Kind == FUZZ_LINK && len(fields) == 4:
+		if f.Peer, err = strconv.Atoi(fields[3]); err != nil {
+			return f, err
+		}
+	case f.Kind == FUZZ_BYZANTINE && len(fields) == 4:
+		for b, name := range behaviourNames {
+			if name == fields[3] {
+				f.Behaviour = Behaviour(b)
+			}
+		}
+		if f.Behaviour == HONEST {
+			return f, fmt.Errorf("unknown behaviour %q", fields[3])
+		}
+	default:
+		return f, fmt.Errorf("unknown fault or wrong arguments")
+	}
+	return f, nil
+}
@@ -0,0 +1,62 @@
+package xpaxos
+
+// Replicated state machines executed by XPaxos servers
+// XPaxos servers apply committed requests in commit log order and the leader returns the result
+// of each request to the client in its reply
+//
+// xp.SetStateMachine(MakeKVStore()) - Execute requests against a key-value store
+// => Operations are KVOp values; other operations (i.e. nil or []byte) are executed as no-ops
+// => Without a state machine, requests are committed but not executed (results are nil)
+//
+// client.Propose(KVOp{PUT, "x", "1"})
+// value, ok := client.Propose(KVOp{GET, "x", ""}) - ok is false if the result is unknown
+
+import (
+	"encoding/gob"
+)
+
+const (
+	GET    = "Get"
+	PUT    = "Put"
+	APPEND = "Append"
+)
+
+type StateMachine interface {
+	Apply(op interface{}) interface{}
+}
+
+type KVOp struct {
+	Method string // GET, PUT or APPEND
+	Key    string
+	Value  string
+}
+
+type KVStore struct {
+	data map[string]string
+}
+
+func init() {
+	gob.Register(KVOp{}) // Operations are sent as interface values
+}
+
+func MakeKVStore() *KVStore {
+	kv := &KVStore{}
+	kv.data = make(map[string]string, 0)
+	return kv
+}
+
+// Returns the value of the key (after the operation) for KVOps and nil otherwise
+func (kv *KVStore) Apply(op interface{}) interface{} {
+	kvOp, ok := op.(KVOp)
+	if ok == false {
+		return nil
+	}
+
+	switch kvOp.Method {
+	case PUT:
+		kv.data[kvOp.Key] = kvOp.Value
+	case APPEND:
+		kv.data[kvOp.Key] += kvOp.Value
+	}
+	return kv.data[kvOp.Key]
+}
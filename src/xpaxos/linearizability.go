@@ -0,0 +1,220 @@
+package xpaxos
+
+// Client histories and a linearizability checker
+// A history records every operation a client proposes (its invocation) and the result it gets
+// back (its response), timed on the network's clock. A history is linearizable if every operation
+// can be placed at a single point between its invocation and its response such that the results
+// match a sequential model of the state machine (see kv.go)
+//
+// h := MakeHistory(clock)                 - Empty history timed on clock (i.e. net.Clock())
+// client.SetHistory(h)                    - Record every client.Propose() to h
+// CheckLinearizable(KVModel, h.Entries()) - Check a history against a sequential model
+// => KVModel       - Key-value store (see KVStore); histories are checked one key at a time
+// => RegisterModel - Single register; every KVOp reads or writes the same value, whatever its key
+// => Operations with an unknown result (i.e. timed out or confirmed by a view change) may or may
+//    not have taken effect, at any point after their invocation
+// => The check is a depth-first search over the orders allowed by real time (Wing & Gong, with
+//    memoization of visited states as in Lowe); it is exponential in the number of concurrent
+//    operations, which is small for XPaxos clients
+
+import (
+	"fmt"
+	"network"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type HistoryEntry struct {
+	ClientId int
+	Input    interface{} // Proposed operation
+	Output   interface{} // Result of the operation (if Ok)
+	Call     time.Time   // Invocation
+	Return   time.Time   // Response (if Ok)
+	Ok       bool        // Whether the result is known
+}
+
+type History struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+	clock   network.Clock
+}
+
+type Model struct {
+	Init      func() interface{}
+	Step      func(state interface{}, input interface{}) (interface{}, interface{}) // New state and output
+	Partition func(entries []HistoryEntry) [][]HistoryEntry                         // Optional; independent sub-histories
+}
+
+var KVModel = Model{
+	Init: func() interface{} { return map[string]string{} },
+	Step: func(state interface{}, input interface{}) (interface{}, interface{}) {
+		kvOp, ok := input.(KVOp)
+		if ok == false {
+			return state, nil
+		}
+		data := state.(map[string]string)
+		newData := make(map[string]string, len(data)) // States are memoized, so never update them in place
+		for key, value := range data {
+			newData[key] = value
+		}
+		kv := &KVStore{data: newData}
+		return newData, kv.Apply(kvOp)
+	},
+	Partition: partitionByKey,
+}
+
+var RegisterModel = Model{
+	Init: func() interface{} { return "" },
+	Step: func(state interface{}, input interface{}) (interface{}, interface{}) {
+		kvOp, ok := input.(KVOp)
+		if ok == false {
+			return state, nil
+		}
+		kv := &KVStore{data: map[string]string{kvOp.Key: state.(string)}}
+		output := kv.Apply(kvOp)
+		return output, output
+	},
+}
+
+func MakeHistory(clock network.Clock) *History {
+	h := &History{}
+	h.entries = make([]HistoryEntry, 0)
+	h.clock = clock
+	return h
+}
+
+// Record an invocation; returns its index in the history (-1 if h is nil)
+func (h *History) invoke(clientId int, input interface{}) int {
+	if h == nil {
+		return -1
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = append(h.entries, HistoryEntry{ClientId: clientId, Input: input, Call: h.clock.Now()})
+	return len(h.entries) - 1
+}
+
+// Record the response to invocation i (ok is false if the result is unknown)
+func (h *History) respond(i int, output interface{}, ok bool) {
+	if h == nil || i < 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries[i].Output = output
+	h.entries[i].Return = h.clock.Now()
+	h.entries[i].Ok = ok
+}
+
+// Copy of the entries recorded so far (operations still in progress have an unknown result)
+func (h *History) Entries() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]HistoryEntry, len(h.entries))
+	copy(entries, h.entries)
+	return entries
+}
+
+func CheckLinearizable(model Model, entries []HistoryEntry) bool {
+	partitions := [][]HistoryEntry{entries}
+	if model.Partition != nil {
+		partitions = model.Partition(entries)
+	}
+
+	for _, partition := range partitions {
+		if checkPartition(model, partition) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// Split a key-value history into one sub-history per key (operations other than KVOps are dropped)
+func partitionByKey(entries []HistoryEntry) [][]HistoryEntry {
+	keys := make(map[string]int, 0)
+	partitions := [][]HistoryEntry{}
+
+	for _, entry := range entries {
+		kvOp, ok := entry.Input.(KVOp)
+		if ok == false {
+			continue
+		}
+		i, ok := keys[kvOp.Key]
+		if ok == false {
+			i = len(partitions)
+			keys[kvOp.Key] = i
+			partitions = append(partitions, []HistoryEntry{})
+		}
+		partitions[i] = append(partitions[i], entry)
+	}
+	return partitions
+}
+
+type linearizer struct {
+	model   Model
+	entries []HistoryEntry
+	done    []bool
+	visited map[string][]interface{} // States already explored for each set of linearized operations
+}
+
+func checkPartition(model Model, entries []HistoryEntry) bool {
+	lin := &linearizer{}
+	lin.model = model
+	lin.entries = entries
+	lin.done = make([]bool, len(entries))
+	lin.visited = make(map[string][]interface{}, 0)
+	return lin.search(model.Init())
+}
+
+// Try every operation that could take effect next from state
+func (lin *linearizer) search(state interface{}) bool {
+	remaining := false
+	var deadline time.Time // Earliest response among the remaining operations with a known result
+
+	for i, entry := range lin.entries {
+		if lin.done[i] == false && entry.Ok == true {
+			if remaining == false || entry.Return.Before(deadline) {
+				deadline = entry.Return
+			}
+			remaining = true
+		}
+	}
+
+	if remaining == false { // Operations with an unknown result may never have taken effect
+		return true
+	}
+
+	key := fmt.Sprint(lin.done)
+	for _, visitedState := range lin.visited[key] {
+		if reflect.DeepEqual(visitedState, state) {
+			return false
+		}
+	}
+	lin.visited[key] = append(lin.visited[key], state)
+
+	for i, entry := range lin.entries {
+		// An operation invoked after another operation returned can't take effect before it
+		if lin.done[i] == true || entry.Call.After(deadline) {
+			continue
+		}
+
+		newState, output := lin.model.Step(state, entry.Input)
+		if entry.Ok == true && reflect.DeepEqual(output, entry.Output) == false {
+			continue
+		}
+
+		lin.done[i] = true
+		ok := lin.search(newState)
+		lin.done[i] = false
+		if ok == true {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package xpaxos
+
+// Proofs of misbehaviour
+// A leader may send conflicting prepares for the same sequence number to different members of its
+// synchronous group. Commit messages carry the leader's signature of the prepare they commit, so
+// every member compares the prepare it was sent with the prepares the others committed. Two
+// prepares signed by the leader of a view for the same sequence number but different requests
+// prove that the leader is faulty, whoever holds them
+//
+// => A proof triggers a view change at once
+// => Proofs are listed by xp.MisbehaviourProofs() (i.e. for operators and exclusion policies)
+//    and can be checked by any XPaxos server with xp.verifyProof(proof)
+//
+// Every XPaxos server keeps a record of the proofs it verified, and forwards each new proof to all
+// the others (proofs are self-certifying, so any XPaxos server can relay them). A convicted
+// replica is excluded from synchronous groups: XPaxos servers skip every view whose synchronous
+// group contains one (see xp.nextView())
+//
+// The record is replicated through the view change, since forwarding proofs is best effort
+// => Suspect and view-change messages carry the sender's proofs, which the receiver verifies and
+//    adds to its record before it computes the next view (i.e. XPaxos servers that process the
+//    same suspect message skip to the same view)
+// => A suspect message whose proofs skip past the view an XPaxos server moved to moves it further,
+//    so XPaxos servers that processed different suspect messages first still meet in one view
+// => The new synchronous group merges the proofs of the view-change messages it collected; if one
+//    of its members is convicted, it suspects the view at once
+// => If every synchronous group of the next MAXSKIP views has a convicted replica, the exclusion
+//    can't be honoured: the next view is kept and the XPaxos server logs it
+//
+// client.Propose(SignReadmit(adminKey, i, nonce)) - Admin command to re-admit XPaxos server i once
+//                                                   it is repaired
+// => The command is committed like any other request, so that every XPaxos server executes it at
+//    the same point of the commit log; only proofs of views after the command's view convict
+//    XPaxos server i again
+// => Client IDs aren't authenticated, so the command must be signed by the operator's private key;
+//    XPaxos servers execute it only if the signature checks with the admin key they were given
+//    (see xp.SetAdminKey()) and its nonce is greater than that of the last re-admission of
+//    XPaxos server i (i.e. a signed command can't be replayed after a later conviction)
+// => XPaxos servers list the replicas they currently exclude with xp.ConvictedReplicas()
+
+import (
+	"bytes"
+	"crypto"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"encoding/gob"
+	"sort"
+)
+
+const MAXSKIP = 1000 // Maximum number of views skipped at once (i.e. if every group has a convicted replica)
+
+type ReadmitOp struct {
+	ReplicaId int
+	Nonce     int    // Greater than that of every earlier re-admission of the replica
+	Signature []byte // Operator's signature of readmitDigest(op)
+}
+
+func init() {
+	gob.Register(ReadmitOp{}) // Operations are sent as interface values
+}
+
+type MisbehaviourProof struct {
+	ReplicaId int     // Leader that signed both prepares
+	Prepare1  Message // Prepare messages for the same view and sequence number, but different requests
+	Prepare2  Message
+}
+
+// Valid proofs hold two prepares signed by the leader of their view for the same sequence number
+func (xp *XPaxos) verifyProof(proof MisbehaviourProof) bool {
+	msg1, msg2 := proof.Prepare1, proof.Prepare2
+
+	if msg1.MsgType != PREPARE || msg2.MsgType != PREPARE || msg1.View != msg2.View ||
+		msg1.PrepareSeqNum != msg2.PrepareSeqNum || bytes.Compare(msg1.MsgDigest[:], msg2.MsgDigest[:]) == 0 {
+		return false
+	}
+	if proof.ReplicaId != xp.leaderOf(msg1.View) || msg1.SenderId != proof.ReplicaId || msg2.SenderId != proof.ReplicaId {
+		return false
+	}
+	return xp.verify(proof.ReplicaId, prepareDigest(msg1), msg1.Signature) == true &&
+		xp.verify(proof.ReplicaId, prepareDigest(msg2), msg2.Signature) == true
+}
+
+// Prepare message of the leader that a commit message commits
+func (xp *XPaxos) committedPrepare(msg Message) Message {
+	return Message{
+		MsgType:         PREPARE,
+		MsgDigest:       msg.MsgDigest,
+		Signature:       msg.PrepareSignature,
+		PrepareSeqNum:   msg.PrepareSeqNum,
+		View:            msg.View,
+		ClientTimestamp: msg.ClientTimestamp,
+		SenderId:        xp.leaderOf(msg.View)}
+}
+
+// Compare a prepare message with the one in the prepare log for its sequence number, keeping a
+// proof of misbehaviour if the leader signed both
+func (xp *XPaxos) checkPrepare(msg Message) {
+	seqNum := msg.PrepareSeqNum - 1
+	if seqNum < 0 || seqNum >= len(xp.prepareLog) {
+		return
+	}
+
+	proof := MisbehaviourProof{msg.SenderId, xp.prepareLog[seqNum].Msg0, msg}
+	if xp.verifyProof(proof) == true {
+		xp.addProof(proof)
+	}
+}
+
+func (xp *XPaxos) addProof(proof MisbehaviourProof) {
+	for _, old := range xp.proofs {
+		if old.ReplicaId == proof.ReplicaId && old.Prepare1.View == proof.Prepare1.View &&
+			old.Prepare1.PrepareSeqNum == proof.Prepare1.PrepareSeqNum {
+			return
+		}
+	}
+
+	iPrintf("Proof of misbehaviour: XPaxos server (%d) sent conflicting prepares (%d) in view (%d)\n",
+		proof.ReplicaId, proof.Prepare1.PrepareSeqNum, proof.Prepare1.View)
+	xp.proofs = append(xp.proofs, proof)
+
+	for server, _ := range xp.replicas {
+		if server != CLIENT && server != xp.id {
+			go xp.issueProof(server, proof, 0)
+		}
+	}
+
+	if xp.convicted(proof.ReplicaId) == true && xp.groupOf(xp.view)[proof.ReplicaId] == true {
+		go xp.issueSuspect(xp.view)
+	}
+}
+
+// Add the valid proofs of a message to the record
+func (xp *XPaxos) adoptProofs(proofs []MisbehaviourProof) {
+	for _, proof := range proofs {
+		if xp.verifyProof(proof) == true {
+			xp.addProof(proof)
+		}
+	}
+}
+
+// Proofs carried by the view-change messages of the XPaxos server
+func (xp *XPaxos) copyProofs() []MisbehaviourProof {
+	proofs := make([]MisbehaviourProof, len(xp.proofs))
+	copy(proofs, xp.proofs)
+	return proofs
+}
+
+// Whether the record holds a proof against a replica from after it was last re-admitted
+func (xp *XPaxos) convicted(server int) bool {
+	for _, proof := range xp.proofs {
+		if proof.ReplicaId == server && proof.Prepare1.View > xp.readmitted[server] {
+			return true
+		}
+	}
+	return false
+}
+
+// First view from view on whose synchronous group has no convicted replica
+func (xp *XPaxos) nextView(view int) int {
+	for skipped := 0; skipped < MAXSKIP; skipped++ {
+		excluded := false
+		for server, _ := range xp.groupOf(view + skipped) {
+			if xp.convicted(server) == true {
+				excluded = true
+			}
+		}
+
+		if excluded == false {
+			return view + skipped
+		}
+	}
+
+	iPrintf("Exclusion: every synchronous group from view (%d) to view (%d) has a convicted replica, "+
+		"keeping view (%d)\n", view, view+MAXSKIP-1, view)
+	return view
+}
+
+// Admin command to re-admit replicaId, signed with the operator's private key
+func SignReadmit(adminKey *rsa.PrivateKey, replicaId int, nonce int) ReadmitOp {
+	op := ReadmitOp{ReplicaId: replicaId, Nonce: nonce}
+	msgDigest := readmitDigest(op)
+	signature, err := rsa.SignPKCS1v15(crand.Reader, adminKey, crypto.SHA256, msgDigest[:])
+	checkError(err)
+	op.Signature = signature
+	return op
+}
+
+// Valid commands are signed with the admin key and newer than the last re-admission of the replica
+func (xp *XPaxos) verifyReadmit(op ReadmitOp) bool {
+	if xp.adminKey == nil || op.Nonce <= xp.readmitNonces[op.ReplicaId] {
+		return false
+	}
+	msgDigest := readmitDigest(op)
+	return rsa.VerifyPKCS1v15(xp.adminKey, crypto.SHA256, msgDigest[:], op.Signature) == nil
+}
+
+// Executed when a ReadmitOp is applied, in the view it was committed in
+func (xp *XPaxos) readmit(op ReadmitOp, view int) {
+	if xp.verifyReadmit(op) == false {
+		iPrintf("Readmit: rejected unsigned re-admission of XPaxos server (%d) in view (%d)\n", op.ReplicaId, view)
+		return
+	}
+
+	iPrintf("Readmit: XPaxos server (%d) re-admitted in view (%d)\n", op.ReplicaId, view)
+	xp.readmitted[op.ReplicaId] = view
+	xp.readmitNonces[op.ReplicaId] = op.Nonce
+}
+
+// Replicas the XPaxos server excludes from synchronous groups
+func (xp *XPaxos) ConvictedReplicas() []int {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	convicted := make([]int, 0)
+	for server, _ := range xp.replicas {
+		if server != CLIENT && xp.convicted(server) == true {
+			convicted = append(convicted, server)
+		}
+	}
+	sort.Ints(convicted)
+	return convicted
+}
+
+// --------------------------------- PROOF RPC --------------------------------
+func (xp *XPaxos) sendProof(server int, proof MisbehaviourProof, reply *Reply) bool {
+	dPrintf("Proof: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
+	return xp.replicas[server].Call("XPaxos.Proof", proof, reply, xp.id)
+}
+
+func (xp *XPaxos) issueProof(server int, proof MisbehaviourProof, retry int) {
+	reply := &Reply{}
+
+	if ok := xp.sendProof(server, proof, reply); ok == false && retry < RETRY {
+		retry++
+		go xp.issueProof(server, proof, retry)
+	}
+}
+
+func (xp *XPaxos) Proof(proof MisbehaviourProof, reply *Reply) {
+	defer xp.signReply(reply)
+
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	if xp.verifyProof(proof) == true {
+		reply.Success = true
+		xp.addProof(proof)
+	}
+}
+
+// Proofs of misbehaviour held by the XPaxos server
+func (xp *XPaxos) MisbehaviourProofs() []MisbehaviourProof {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	return xp.copyProofs()
+}
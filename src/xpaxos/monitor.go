@@ -0,0 +1,154 @@
+package xpaxos
+
+// Online safety monitor (test harness)
+// Every XPaxos server reports the requests it commits and executes and the commit log it installs
+// with each new view, so that safety violations are caught the moment they happen, across views and
+// including servers that are not in the current synchronous group or are changing view
+//
+// m := MakeMonitor(fail) - Monitor that calls fail(violation) on every safety violation
+// xp.SetMonitor(m)       - Report the XPaxos server's commit, execute and new-view events to m
+// m.stop()               - Stop reporting violations (i.e. once the test is over)
+// => Two XPaxos servers must never commit or execute different requests at the same sequence
+//    number (in the same view or not), whether or not the committed requests are ever executed
+// => An XPaxos server commits a request once its commit log entry holds the commits of every
+//    other member of the synchronous group
+// => Once any XPaxos server committed or executed a request, every commit log installed by a new
+//    view, followed by the requests the new view prepares first (see certificate.go), must hold
+//    that request at the same sequence number
+
+import (
+	"fmt"
+	"sync"
+)
+
+type executedEntry struct {
+	Digest   [32]byte // Digest of the request
+	View     int
+	SenderId int // First XPaxos server that committed (or executed) the request
+}
+
+type Monitor struct {
+	mu         sync.Mutex
+	committed  map[int]executedEntry // Request committed at each sequence number
+	executed   map[int]executedEntry // Request executed at each sequence number
+	violations []string
+	fail       func(violation string)
+	stopped    bool
+}
+
+func MakeMonitor(fail func(violation string)) *Monitor {
+	m := &Monitor{}
+	m.committed = make(map[int]executedEntry, 0)
+	m.executed = make(map[int]executedEntry, 0)
+	m.violations = make([]string, 0)
+	m.fail = fail
+	m.stopped = false
+	return m
+}
+
+// XPaxos server id committed commitEntry at seqNum in view
+func (m *Monitor) commit(id int, view int, seqNum int, commitEntry CommitLogEntry) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.record(m.committed, "committed", id, view, seqNum, digest(commitEntry.Request))
+}
+
+// XPaxos server id executed commitEntry at seqNum in view
+func (m *Monitor) execute(id int, view int, seqNum int, commitEntry CommitLogEntry) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.record(m.executed, "executed", id, view, seqNum, digest(commitEntry.Request))
+}
+
+// Must hold m.mu; committed and executed requests are checked against each other too
+func (m *Monitor) record(events map[int]executedEntry, event string, id int, view int, seqNum int, msgDigest [32]byte) {
+	first, ok := events[seqNum]
+	if ok == false {
+		events[seqNum] = executedEntry{msgDigest, view, id}
+	} else if first.Digest != msgDigest {
+		m.violate(fmt.Sprintf("XPaxos servers (%d) in view (%d) and (%d) in view (%d) %s different requests at sequence number (%d)",
+			first.SenderId, first.View, id, view, event, seqNum))
+		return
+	}
+
+	for _, other := range []map[int]executedEntry{m.committed, m.executed} {
+		if first, ok := other[seqNum]; ok && first.Digest != msgDigest {
+			m.violate(fmt.Sprintf("XPaxos server (%d) in view (%d) %s a request at sequence number (%d) that conflicts with XPaxos server (%d) in view (%d)",
+				id, view, event, seqNum, first.SenderId, first.View))
+			return
+		}
+	}
+}
+
+// XPaxos server id installed commitLog in a new view, which prepares the pending requests next
+func (m *Monitor) newView(id int, view int, commitLog []CommitLogEntry, pending []ClientRequest) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	installed := make([][32]byte, 0, len(commitLog)+len(pending))
+	for _, commitEntry := range commitLog {
+		installed = append(installed, digest(commitEntry.Request))
+	}
+	for _, request := range pending {
+		installed = append(installed, digest(request))
+	}
+
+	m.checkInstalled(m.committed, nil, "committed", id, view, installed)
+	m.checkInstalled(m.executed, m.committed, "executed", id, view, installed) // Report each lost request once
+}
+
+// Must hold m.mu; requests that are also in checked were already checked
+func (m *Monitor) checkInstalled(events map[int]executedEntry, checked map[int]executedEntry, event string, id int,
+	view int, installed [][32]byte) {
+	for seqNum, first := range events {
+		if other, ok := checked[seqNum]; ok && other.Digest == first.Digest {
+			continue
+		}
+
+		if seqNum >= len(installed) {
+			m.violate(fmt.Sprintf("XPaxos server (%d) lost the request %s at sequence number (%d) in new view (%d)",
+				id, event, seqNum, view))
+		} else if installed[seqNum] != first.Digest {
+			m.violate(fmt.Sprintf("XPaxos server (%d) replaced the request %s at sequence number (%d) in new view (%d)",
+				id, event, seqNum, view))
+		}
+	}
+}
+
+func (m *Monitor) violate(violation string) {
+	m.violations = append(m.violations, violation)
+	if m.stopped == false {
+		m.fail(violation)
+	}
+}
+
+// Safety violations seen so far
+func (m *Monitor) Violations() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	violations := make([]string, len(m.violations))
+	copy(violations, m.violations)
+	return violations
+}
+
+func (m *Monitor) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = true
+}
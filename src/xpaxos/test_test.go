@@ -1,9 +1,16 @@
 package xpaxos
 
 import (
+	"crypto/rsa"
+	"encoding/json"
 	"fmt"
 	"math/rand"
+	"network"
+	"os"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 // We need to test more Byzantine faults such as bit flipping!
@@ -98,6 +105,45 @@ func TestCommonCase4(t *testing.T) {
 	compareCommitLogEntries(cfg)
 }
 
+func TestCommonCase5(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Common Case - Message Complexity (t=1)")
+
+	cfg.net.ResetStats()
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+	}
+
+	// Wait until every XPaxos server has replied to the client's Replicate calls (the client only
+	// waits for the replies it needs)
+	replies := network.Filter{SvcMeth: "XPaxos.Replicate", Src: network.ANY, Dst: network.ANY, Replies: true}
+	for i := 0; cfg.net.Stats().Sum(replies).Count < iters*(servers-1); i++ {
+		if i == 1000 {
+			t.Fatalf("Replicate calls did not finish (%d replies)!", cfg.net.Stats().Sum(replies).Count)
+		}
+		cfg.net.Clock().Sleep(time.Millisecond)
+	}
+
+	// The client sends each request to every XPaxos server, the leader prepares it at the
+	// follower and the follower commits it at the leader
+	stats := cfg.net.Stats()
+	expected := map[string]int{"XPaxos.Replicate": iters * (servers - 1), "XPaxos.Prepare": iters,
+		"XPaxos.Commit": iters}
+	for svcMeth, count := range expected {
+		if requests := stats.Sum(network.Filter{SvcMeth: svcMeth, Src: network.ANY, Dst: network.ANY}); requests.Count != count {
+			t.Fatalf("Invalid number of %s requests (%d, expected %d)!", svcMeth, requests.Count, count)
+		}
+	}
+	if vcs := stats.Sum(network.Filter{SvcMeth: "XPaxos.ViewChange", Src: network.ANY, Dst: network.ANY}); vcs.Count != 0 {
+		t.Fatalf("Unexpected view change (%d XPaxos.ViewChange requests)!", vcs.Count)
+	}
+}
+
 func TestFullNetworkPartition1(t *testing.T) {
 	servers := 4
 	cfg := makeConfig(t, servers, false)
@@ -194,7 +240,7 @@ func TestFullNetworkPartition4(t *testing.T) {
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	crash := rand.Intn(servers-1) + 1
+	crash := cfg.rand.Intn(servers-1) + 1
 	cfg.net.SetFaultRate(crash, 100)
 
 	fmt.Println("Test: Full Network Partition - Multiple Crash Failures (t=1)")
@@ -203,7 +249,7 @@ func TestFullNetworkPartition4(t *testing.T) {
 	for i := 0; i < iters; i++ {
 		cfg.client.Propose(nil)
 		cfg.net.SetFaultRate(crash, 0)
-		crash = rand.Intn(servers-1) + 1
+		crash = cfg.rand.Intn(servers-1) + 1
 		cfg.net.SetFaultRate(crash, 100)
 	}
 
@@ -272,8 +318,8 @@ func TestFullNetworkPartition6(t *testing.T) {
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	crash1 := rand.Intn(servers-1) + 1
-	crash2 := rand.Intn(servers-1) + 1
+	crash1 := cfg.rand.Intn(servers-1) + 1
+	crash2 := cfg.rand.Intn(servers-1) + 1
 	cfg.net.SetFaultRate(crash1, 100)
 	cfg.net.SetFaultRate(crash2, 100)
 
@@ -284,8 +330,8 @@ func TestFullNetworkPartition6(t *testing.T) {
 		cfg.client.Propose(nil)
 		cfg.net.SetFaultRate(crash1, 0)
 		cfg.net.SetFaultRate(crash2, 0)
-		crash1 = rand.Intn(servers-1) + 1
-		crash2 = rand.Intn(servers-1) + 1
+		crash1 = cfg.rand.Intn(servers-1) + 1
+		crash2 = cfg.rand.Intn(servers-1) + 1
 		cfg.net.SetFaultRate(crash1, 100)
 		cfg.net.SetFaultRate(crash2, 100)
 	}
@@ -344,7 +390,7 @@ func TestPartialNetworkPartition3(t *testing.T) {
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	partial := rand.Intn(servers-1) + 1
+	partial := cfg.rand.Intn(servers-1) + 1
 	cfg.net.SetFaultRate(partial, 50)
 
 	fmt.Println("Test: Partial Network Partition - Multiple Partial Failures (t=1)")
@@ -353,7 +399,7 @@ func TestPartialNetworkPartition3(t *testing.T) {
 	for i := 0; i < iters; i++ {
 		cfg.client.Propose(nil)
 		cfg.net.SetFaultRate(partial, 0)
-		partial = rand.Intn(servers-1) + 1
+		partial = cfg.rand.Intn(servers-1) + 1
 		cfg.net.SetFaultRate(partial, 50)
 	}
 
@@ -368,8 +414,8 @@ func TestPartialNetworkPartition4(t *testing.T) {
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	partial1 := rand.Intn(servers-1) + 1
-	partial2 := rand.Intn(servers-1) + 1
+	partial1 := cfg.rand.Intn(servers-1) + 1
+	partial2 := cfg.rand.Intn(servers-1) + 1
 	cfg.net.SetFaultRate(partial1, 25)
 	cfg.net.SetFaultRate(partial2, 75)
 
@@ -380,8 +426,8 @@ func TestPartialNetworkPartition4(t *testing.T) {
 		cfg.client.Propose(nil)
 		cfg.net.SetFaultRate(partial1, 0)
 		cfg.net.SetFaultRate(partial2, 0)
-		partial1 = rand.Intn(servers-1) + 1
-		partial2 = rand.Intn(servers-1) + 1
+		partial1 = cfg.rand.Intn(servers-1) + 1
+		partial2 = cfg.rand.Intn(servers-1) + 1
 		cfg.net.SetFaultRate(partial1, 25)
 		cfg.net.SetFaultRate(partial2, 75)
 	}
@@ -392,13 +438,136 @@ func TestPartialNetworkPartition4(t *testing.T) {
 	compareCommitLogEntries(cfg)
 }
 
+func TestLinkPartition1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// Directed link from XPaxos server (ID = 2) to XPaxos server (ID = 3) fails
+	cfg.net.SetLinkEnabled(2, 3, false)
+
+	fmt.Println("Test: Link Partition - Single Directed Link Failure (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+
+	// XPaxos server (ID = 1) is cut off from the other XPaxos servers (but not the client)
+	cfg.net.SetLinkEnabled(2, 3, true)
+	cfg.net.Partition("isolate-1", []int{1}, []int{2, 3})
+
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+	}
+
+	cfg.net.Heal("isolate-1")
+
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestLinkPartition2(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Link Partition - Changing Partitions (t>1)")
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		minority := []int{cfg.rand.Intn(servers-1) + 1}
+		majority := []int{}
+		for j := 1; j < servers; j++ {
+			if j != minority[0] {
+				majority = append(majority, j)
+			}
+		}
+
+		cfg.net.Partition("minority", minority, majority)
+		cfg.client.Propose(nil)
+		cfg.net.Heal("minority")
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestSimulatedNetworkPartition(t *testing.T) {
+	servers := 4
+	cfg := makeSimConfig(t, servers, false, 518)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Simulated Network - Multiple Crash Failures (t=1)")
+
+	start := cfg.net.Clock().Now()
+
+	iters := 10
+	for i := 0; i < iters; i++ {
+		crash := cfg.rand.Intn(servers-1) + 1
+		cfg.net.SetFaultRate(crash, 100)
+		cfg.client.Propose(nil)
+		cfg.net.SetFaultRate(crash, 0)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+
+	iPrintf("Simulated time: %v\n", cfg.net.Clock().Now().Sub(start))
+}
+
+func TestFaultSchedule(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Fault Schedule - Crash, Byzantine and Partition Faults (t=1)")
+
+	s := cfg.makeSchedule()
+	s.AfterOps(3).Crash(2)
+	s.AfterOps(6).Recover(2).AfterOps(6).Byzantine(3)
+	s.AfterOps(9).Honest(3).AfterOps(9).Partition("isolate-1", []int{1}, []int{2, 3})
+	s.AfterOps(12).Heal("isolate-1")
+	s.Start()
+	defer s.Stop()
+
+	iters := 15
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+		s.Op()
+	}
+
+	if fired := s.Fired(); len(fired) != 6 {
+		t.Fatalf("Invalid fired events (%v)!", fired)
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
 func TestByzantineFault1(t *testing.T) {
 	servers := 4
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
 	// XPaxos server (ID = 2) reshuffles bytes in the signature of messages it sends
-	cfg.xpServers[2].byzantine = true
+	cfg.setByzantine(2, true)
 
 	fmt.Println("Test: Byzantine Fault - Single Failure (t=1)")
 
@@ -418,9 +587,9 @@ func TestByzantineFault2(t *testing.T) {
 	defer cfg.cleanup()
 
 	// XPaxos servers (ID = 2, 4, 6) reshuffle bytes in the signature of messages they send
-	cfg.xpServers[2].byzantine = true
-	cfg.xpServers[4].byzantine = true
-	cfg.xpServers[6].byzantine = true
+	cfg.setByzantine(2, true)
+	cfg.setByzantine(4, true)
+	cfg.setByzantine(6, true)
 
 	fmt.Println("Test: Byzantine Fault - Single Failure (t>1)")
 
@@ -439,17 +608,17 @@ func TestByzantineFault3(t *testing.T) {
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	fault := rand.Intn(servers-1) + 1
-	cfg.xpServers[fault].byzantine = true
+	fault := cfg.rand.Intn(servers-1) + 1
+	cfg.setByzantine(fault, true)
 
 	fmt.Println("Test: Byzantine Fault - Multiple Failures (t=1)")
 
 	iters := 50
 	for i := 0; i < iters; i++ {
 		cfg.client.Propose(nil)
-		cfg.xpServers[fault].byzantine = false
-		fault = rand.Intn(servers-1) + 1
-		cfg.xpServers[fault].byzantine = true
+		cfg.setByzantine(fault, false)
+		fault = cfg.rand.Intn(servers-1) + 1
+		cfg.setByzantine(fault, true)
 	}
 
 	comparePrepareSeqNums(cfg)
@@ -463,22 +632,22 @@ func TestByzantineFault4(t *testing.T) {
 	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	fault1 := rand.Intn(servers-1) + 1
-	fault2 := rand.Intn(servers-1) + 1
-	cfg.xpServers[fault1].byzantine = true
-	cfg.xpServers[fault2].byzantine = true
+	fault1 := cfg.rand.Intn(servers-1) + 1
+	fault2 := cfg.rand.Intn(servers-1) + 1
+	cfg.setByzantine(fault1, true)
+	cfg.setByzantine(fault2, true)
 
 	fmt.Println("Test: Byzantine Fault - Multiple Failures (t>1)")
 
 	iters := 10
 	for i := 0; i < iters; i++ {
 		cfg.client.Propose(nil)
-		cfg.xpServers[fault1].byzantine = false
-		cfg.xpServers[fault2].byzantine = false
-		fault1 = rand.Intn(servers-1) + 1
-		fault2 = rand.Intn(servers-1) + 1
-		cfg.xpServers[fault1].byzantine = true
-		cfg.xpServers[fault2].byzantine = true
+		cfg.setByzantine(fault1, false)
+		cfg.setByzantine(fault2, false)
+		fault1 = cfg.rand.Intn(servers-1) + 1
+		fault2 = cfg.rand.Intn(servers-1) + 1
+		cfg.setByzantine(fault1, true)
+		cfg.setByzantine(fault2, true)
 	}
 
 	comparePrepareSeqNums(cfg)
@@ -487,128 +656,1561 @@ func TestByzantineFault4(t *testing.T) {
 	compareCommitLogEntries(cfg)
 }
 
-//
-// ---------------------------- BENCHMARK FUNCTIONS ---------------------------
-//
-func benchmarkNoFaults(n int, size int, b *testing.B) {
-	servers := n // The number of XPaxos servers is n-1 (client included!)
-	cfg := makeConfig(nil, servers, false)
+func TestByzantineFault5(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	op := make([]byte, size)
-	rand.Read(op) // Operation is random byte array of size bytes
+	// XPaxos server (ID = 1) is the leader of view 1 and sends a different prepare to each follower
+	cfg.setBehaviour(1, EQUIVOCATE)
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		cfg.client.Propose(op)
+	fmt.Println("Test: Byzantine Fault - Equivocating Leader (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+
+	if getCurrentView(cfg) == 1 {
+		t.Fatal("Equivocating leader not detected!")
 	}
+	checkMisbehaviourProofs(cfg, 1)
 }
 
-func benchmarkRandomCrashFaults1(n int, size int, b *testing.B) {
-	servers := n // The number of XPaxos servers is n-1 (client included!)
-	cfg := makeConfig(nil, servers, false)
+func TestByzantineFault6(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	crash := rand.Intn(servers-1) + 1
-	cfg.net.SetFaultRate(crash, 100)
+	cfg.setBehaviour(1, EQUIVOCATE)
 
-	op := make([]byte, size)
-	rand.Read(op) // Operation is random byte array of size bytes
+	fmt.Println("Test: Byzantine Fault - Equivocating Leader (t>1)")
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		cfg.client.Propose(op)
-		cfg.net.SetFaultRate(crash, 0)
-		crash = rand.Intn(servers-1) + 1
-		cfg.net.SetFaultRate(crash, 100)
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+
+	if getCurrentView(cfg) == 1 {
+		t.Fatal("Equivocating leader not detected!")
 	}
+	checkMisbehaviourProofs(cfg, 1)
 }
 
-func benchmarkRandomCrashFaults2(n int, size int, b *testing.B) {
-	servers := n // The number of XPaxos servers is n-1 (client included!)
-	cfg := makeConfig(nil, servers, false)
+// Crash the leader of the current view for one operation after every iters operations, so that
+// views change while a Byzantine server takes part in them (n.b. this is a second fault, so use
+// t>1 unless the Byzantine server only misbehaves in some views)
+func proposeWithViewChanges(cfg *config, iters int, rounds int) {
+	for i := 0; i < rounds; i++ {
+		for j := 0; j < iters; j++ {
+			cfg.client.Propose(nil)
+		}
+
+		leader := cfg.xpServers[1].leaderOf(getCurrentView(cfg))
+		cfg.net.SetFaultRate(leader, 100)
+		cfg.client.Propose(nil)
+		cfg.net.SetFaultRate(leader, 0)
+	}
+}
+
+func TestByzantineFault7(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	crash1 := rand.Intn(servers-1) + 1
-	crash2 := rand.Intn(servers-1) + 1
-	cfg.net.SetFaultRate(crash1, 100)
-	cfg.net.SetFaultRate(crash2, 100)
+	// XPaxos server (ID = 2) forges the second half of the commit log it sends during view changes
+	cfg.setBehaviour(2, FORGE_LOG)
 
-	op := make([]byte, size)
-	rand.Read(op) // Operation is random byte array of size bytes
+	fmt.Println("Test: Byzantine Fault - Forged Commit Logs (t>1)")
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		cfg.client.Propose(op)
-		cfg.net.SetFaultRate(crash1, 0)
-		cfg.net.SetFaultRate(crash2, 0)
-		crash1 = rand.Intn(servers-1) + 1
-		crash2 = rand.Intn(servers-1) + 1
-		cfg.net.SetFaultRate(crash1, 100)
-		cfg.net.SetFaultRate(crash2, 100)
-	}
+	proposeWithViewChanges(cfg, 4, 4)
+
+	checkNotCommitted(cfg, []byte("forged"))
+	checkFaultReports(cfg, 2)
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
 }
 
-func benchmarkByzantineFault(n int, size int, b *testing.B) {
-	servers := n // The number of XPaxos servers is n-1 (client included!)
-	cfg := makeConfig(nil, servers, false)
+func TestByzantineFault8(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
 	defer cfg.cleanup()
 
-	fault := rand.Intn(servers-1) + 1
-	cfg.xpServers[fault].byzantine = true
+	// XPaxos server (ID = 2) truncates the commit log it sends during view changes
+	cfg.setBehaviour(2, TRUNCATE_LOG)
 
-	op := make([]byte, size)
-	rand.Read(op) // Operation is random byte array of size bytes
+	fmt.Println("Test: Byzantine Fault - Truncated Commit Logs (t>1)")
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		cfg.client.Propose(op)
-		cfg.xpServers[fault].byzantine = false
-		fault = rand.Intn(servers-1) + 1
-		cfg.xpServers[fault].byzantine = true
+	proposeWithViewChanges(cfg, 4, 4)
+
+	checkFaultReports(cfg, CLIENT) // A truncated commit log is made of valid certificates
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestByzantineFault9(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos server (ID = 2) replays its messages of old views whenever the view changes
+	cfg.setBehaviour(2, REPLAY)
+
+	fmt.Println("Test: Byzantine Fault - Replayed Old-View Messages (t>1)")
+
+	proposeWithViewChanges(cfg, 3, 4)
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestByzantineFault10(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos server (ID = 2) is the leader of view 2 but never sends NEWVIEW
+	cfg.setBehaviour(2, WITHHOLD_NEWVIEW)
+
+	fmt.Println("Test: Byzantine Fault - Leader Withholds New View (t=1)")
+
+	proposeWithViewChanges(cfg, 3, 1)
+
+	if getCurrentView(cfg) <= 2 {
+		t.Fatal("Withheld new view not detected!")
+	}
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
 	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
 }
 
-// Benchmark_3_0 - Number of XPaxos servers = 3 (t=1), No Faults
-func Benchmark_3_0_1kB(b *testing.B)   { benchmarkNoFaults(4, 1024, b) }
-func Benchmark_3_0_2kB(b *testing.B)   { benchmarkNoFaults(4, 2048, b) }
-func Benchmark_3_0_4kB(b *testing.B)   { benchmarkNoFaults(4, 4096, b) }
-func Benchmark_3_0_8kB(b *testing.B)   { benchmarkNoFaults(4, 8192, b) }
-func Benchmark_3_0_16kB(b *testing.B)  { benchmarkNoFaults(4, 16384, b) }
-func Benchmark_3_0_32kB(b *testing.B)  { benchmarkNoFaults(4, 32768, b) }
-func Benchmark_3_0_64kB(b *testing.B)  { benchmarkNoFaults(4, 65536, b) }
-func Benchmark_3_0_128kB(b *testing.B) { benchmarkNoFaults(4, 131072, b) }
-func Benchmark_3_0_256kB(b *testing.B) { benchmarkNoFaults(4, 262144, b) }
-func Benchmark_3_0_512kB(b *testing.B) { benchmarkNoFaults(4, 524288, b) }
-func Benchmark_3_0_1MB(b *testing.B)   { benchmarkNoFaults(4, 1048576, b) }
-func Benchmark_3_0_2MB(b *testing.B)   { benchmarkNoFaults(4, 2097152, b) }
-func Benchmark_3_0_4MB(b *testing.B)   { benchmarkNoFaults(4, 4194304, b) }
-func Benchmark_3_0_8MB(b *testing.B)   { benchmarkNoFaults(4, 8388608, b) }
+func TestByzantineFault11(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
 
-// Benchmark_5_0 - Number of XPaxos servers = 5 (t=2), No Faults
-func Benchmark_5_0_1kB(b *testing.B)   { benchmarkNoFaults(6, 1024, b) }
-func Benchmark_5_0_2kB(b *testing.B)   { benchmarkNoFaults(6, 2048, b) }
-func Benchmark_5_0_4kB(b *testing.B)   { benchmarkNoFaults(6, 4096, b) }
-func Benchmark_5_0_8kB(b *testing.B)   { benchmarkNoFaults(6, 8192, b) }
-func Benchmark_5_0_16kB(b *testing.B)  { benchmarkNoFaults(6, 16384, b) }
-func Benchmark_5_0_32kB(b *testing.B)  { benchmarkNoFaults(6, 32768, b) }
-func Benchmark_5_0_64kB(b *testing.B)  { benchmarkNoFaults(6, 65536, b) }
-func Benchmark_5_0_128kB(b *testing.B) { benchmarkNoFaults(6, 131072, b) }
-func Benchmark_5_0_256kB(b *testing.B) { benchmarkNoFaults(6, 262144, b) }
-func Benchmark_5_0_512kB(b *testing.B) { benchmarkNoFaults(6, 524288, b) }
-func Benchmark_5_0_1MB(b *testing.B)   { benchmarkNoFaults(6, 1048576, b) }
-func Benchmark_5_0_2MB(b *testing.B)   { benchmarkNoFaults(6, 2097152, b) }
-func Benchmark_5_0_4MB(b *testing.B)   { benchmarkNoFaults(6, 4194304, b) }
-func Benchmark_5_0_8MB(b *testing.B)   { benchmarkNoFaults(6, 8388608, b) }
+	// The follower of view 1 drops every other commit message it sends
+	follower := 0
+	for server, _ := range cfg.xpServers[1].synchronousGroup {
+		if server != 1 {
+			follower = server
+		}
+	}
+	cfg.setBehaviour(follower, DROP_COMMITS)
 
-// Benchmark_11_0 - Number of XPaxos servers = 11 (t=5), No Faults
-func Benchmark_11_0_1kB(b *testing.B)   { benchmarkNoFaults(12, 1024, b) }
-func Benchmark_11_0_2kB(b *testing.B)   { benchmarkNoFaults(12, 2048, b) }
-func Benchmark_11_0_4kB(b *testing.B)   { benchmarkNoFaults(12, 4096, b) }
-func Benchmark_11_0_8kB(b *testing.B)   { benchmarkNoFaults(12, 8192, b) }
-func Benchmark_11_0_16kB(b *testing.B)  { benchmarkNoFaults(12, 16384, b) }
-func Benchmark_11_0_32kB(b *testing.B)  { benchmarkNoFaults(12, 32768, b) }
-func Benchmark_11_0_64kB(b *testing.B)  { benchmarkNoFaults(12, 65536, b) }
+	fmt.Println("Test: Byzantine Fault - Follower Drops Commits (t=1)")
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+
+	if getCurrentView(cfg) == 1 {
+		t.Fatal("Dropped commits not detected!")
+	}
+}
+
+func TestByzantineFault12(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// The replies of the follower of view 1 to prepare and commit messages are altered in transit
+	follower := 0
+	for server, _ := range cfg.xpServers[1].synchronousGroup {
+		if server != 1 {
+			follower = server
+		}
+	}
+	cfg.setBehaviour(follower, TAMPER_REPLIES)
+
+	fmt.Println("Test: Byzantine Fault - Tampered Replies (t=1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+
+	if getCurrentView(cfg) == 1 {
+		t.Fatal("Tampered replies not detected!")
+	}
+}
+
+func TestExclusion(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// XPaxos server (ID = 1) is the leader of view 1 and equivocates until it is convicted
+	cfg.setBehaviour(1, EQUIVOCATE)
+
+	fmt.Println("Test: Exclusion of Convicted Replicas (t>1)")
+
+	cfg.client.Propose(nil)
+	cfg.setBehaviour(1, HONEST)
+
+	proposeWithViewChanges(cfg, 2, 3)
+
+	// Every XPaxos server holds the proof, and skips views whose synchronous group has XPaxos server
+	// (ID = 1) in it
+	currentView := getCurrentView(cfg)
+	excluded := currentView + 1
+	for cfg.xpServers[1].groupOf(excluded)[1] == false {
+		excluded++
+	}
+
+	for i := 1; i < cfg.n; i++ {
+		xp := cfg.xpServers[i]
+		if convicted := xp.ConvictedReplicas(); len(convicted) != 1 || convicted[0] != 1 {
+			t.Fatalf("XPaxos server (%d) convicted XPaxos servers (%v)!", i, convicted)
+		}
+
+		xp.mu.Lock()
+		if xp.groupOf(xp.view)[1] == true || xp.nextView(excluded) == excluded {
+			xp.mu.Unlock()
+			t.Fatalf("XPaxos server (%d) did not exclude XPaxos server (1)!", i)
+		}
+		xp.mu.Unlock()
+	}
+
+	// Any client can propose a re-admission, so one that isn't signed by the operator (or is signed
+	// by another key) is committed but not executed
+	cfg.client.Propose(ReadmitOp{ReplicaId: 1, Nonce: 1})
+	cfg.client.Propose(SignReadmit(cfg.privateKeys[1], 1, 1))
+	cfg.client.Propose(nil)
+
+	for i := 1; i < cfg.n; i++ {
+		xp := cfg.xpServers[i]
+		xp.mu.Lock()
+		readmitted := xp.convicted(1) == false || xp.nextView(excluded) == excluded
+		xp.mu.Unlock()
+
+		if readmitted == true {
+			t.Fatalf("XPaxos server (%d) executed an unsigned re-admission of XPaxos server (1)!", i)
+		}
+	}
+
+	// Re-admission is committed, so the synchronous group of the current view executes it at once
+	cfg.client.Propose(SignReadmit(cfg.adminKey, 1, 1))
+	cfg.client.Propose(nil)
+
+	for i := 1; i < cfg.n; i++ {
+		xp := cfg.xpServers[i]
+		xp.mu.Lock()
+		member := xp.view == currentView && len(xp.synchronousGroup) > 0
+		readmitted := xp.convicted(1) == false && xp.nextView(excluded) == excluded
+		xp.mu.Unlock()
+
+		if member == true && readmitted == false {
+			t.Fatalf("XPaxos server (%d) did not re-admit XPaxos server (1)!", i)
+		}
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestExclusionAgreement(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Agreement on Convicted Replicas in View Changes (t>1)")
+
+	cfg.client.Propose(nil)
+
+	// XPaxos server (ID = 2) is the leader of view 2; only XPaxos server (ID = 1) holds the proof,
+	// which it doesn't forward, so the others learn of it from the view-change protocol alone
+	request := ClientRequest{MsgType: REPLICATE, Timestamp: 0, Operation: "a", ClientId: CLIENT}
+	prepare1 := Message{MsgType: PREPARE, MsgDigest: digest(request), PrepareSeqNum: 1, View: 2, SenderId: 2}
+	prepare1.Signature = forgeSignature(cfg.privateKeys[2], prepareDigest(prepare1))
+	request.Operation = "b"
+	prepare2 := prepare1
+	prepare2.MsgDigest = digest(request)
+	prepare2.Signature = forgeSignature(cfg.privateKeys[2], prepareDigest(prepare2))
+
+	xp := cfg.xpServers[1]
+	xp.mu.Lock()
+	xp.proofs = append(xp.proofs, MisbehaviourProof{2, prepare1, prepare2})
+	view := xp.view
+	xp.mu.Unlock()
+
+	xp.issueSuspect(view)
+	cfg.client.Propose(nil)
+
+	currentView := getCurrentView(cfg)
+	if cfg.xpServers[1].groupOf(currentView)[2] == true {
+		t.Fatalf("View (%d) has convicted XPaxos server (2) in its synchronous group!", currentView)
+	}
+
+	for i := 1; i < cfg.n; i++ {
+		if convicted := cfg.xpServers[i].ConvictedReplicas(); len(convicted) != 1 || convicted[0] != 2 {
+			t.Fatalf("XPaxos server (%d) convicted XPaxos servers (%v)!", i, convicted)
+		}
+
+		cfg.xpServers[i].mu.Lock()
+		if cfg.xpServers[i].view != currentView {
+			cfg.xpServers[i].mu.Unlock()
+			t.Fatalf("XPaxos server (%d) is in view (%d), not view (%d)!", i, cfg.xpServers[i].view, currentView)
+		}
+		cfg.xpServers[i].mu.Unlock()
+	}
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestFaultyClient1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// Faulty client (ClientId = 1) sends a different request with the same timestamp to each replica
+	fc := cfg.makeFaultyClient(1, EQUIVOCATING_CLIENT)
+
+	fmt.Println("Test: Faulty Client - Equivocating Requests (t=1)")
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		fc.propose()
+		cfg.client.Propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+
+	if count := countClientRequests(cfg, CLIENT); count != iters {
+		t.Fatalf("Correct client only committed %d of %d requests!", count, iters)
+	}
+	countClientRequests(cfg, 1)
+}
+
+func TestFaultyClient2(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// Faulty client (ClientId = 1) floods replicas with huge timestamps, also as the correct client
+	fc := cfg.makeFaultyClient(1, FLOODING_CLIENT)
+
+	fmt.Println("Test: Faulty Client - Huge Timestamps (t=1)")
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		fc.propose()
+		cfg.client.Propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+
+	if count := countClientRequests(cfg, CLIENT); count != iters {
+		t.Fatalf("Correct client only committed %d of %d requests!", count, iters)
+	}
+	if count := countClientRequests(cfg, 1); count != 0 {
+		t.Fatalf("Committed %d requests with huge timestamps!", count)
+	}
+}
+
+func TestFaultyClient3(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// Faulty clients (ClientId = 1, 2) send malformed requests and equivocate
+	fc1 := cfg.makeFaultyClient(1, MALFORMED_CLIENT)
+	fc2 := cfg.makeFaultyClient(2, EQUIVOCATING_CLIENT)
+
+	fmt.Println("Test: Faulty Client - Malformed Requests (t>1)")
+
+	iters := 6
+	for i := 0; i < iters; i++ {
+		fc1.propose()
+		fc2.propose()
+		cfg.client.Propose(nil)
+		comparePrepareSeqNums(cfg)
+		compareExecuteSeqNums(cfg)
+		comparePrepareLogEntries(cfg)
+		compareCommitLogEntries(cfg)
+	}
+
+	if count := countClientRequests(cfg, CLIENT); count != iters {
+		t.Fatalf("Correct client only committed %d of %d requests!", count, iters)
+	}
+	if count := countClientRequests(cfg, 1); count != 0 {
+		t.Fatalf("Committed %d malformed requests!", count)
+	}
+	if getCurrentView(cfg) != 1 {
+		t.Fatal("Faulty clients caused a view change!")
+	}
+}
+
+// Client IDs aren't authenticated, so this documents a known limitation (see validRequest()):
+// the correct client's later requests are never committed
+func TestFaultyClient4(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	// Faulty client sends requests as the correct client, pushing its timestamp WINDOW ahead each time
+	fc := cfg.makeFaultyClient(1, IMPERSONATING_CLIENT)
+
+	fmt.Println("Test: Faulty Client - Impersonating Requests (t=1)")
+
+	iters := 2
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+	}
+
+	rounds := 3
+	for i := 0; i < rounds; i++ {
+		fc.propose()
+	}
+
+	leader := cfg.xpServers[cfg.xpServers[1].getLeader()]
+	leader.mu.Lock()
+	timestamp := leader.getClientTimestamp(CLIENT)
+	leader.mu.Unlock()
+	if timestamp != rounds*WINDOW-1 {
+		t.Fatalf("Impersonated requests moved the correct client's timestamp to %d!", timestamp)
+	}
+	if count := countClientRequests(cfg, CLIENT); count != iters+rounds {
+		t.Fatalf("Committed %d requests as the correct client, expected %d!", count, iters+rounds)
+	}
+
+	// The correct client's next request looks like a duplicate: it is neither committed nor
+	// given a commit proof
+	request := ClientRequest{MsgType: REPLICATE, Timestamp: iters, Operation: nil, ClientId: CLIENT}
+	reply := &Reply{}
+	if ok := cfg.net.Inject(CLIENT, leader.id, "XPaxos.Replicate", request, reply); ok == false {
+		t.Fatal("Replicate call failed!")
+	}
+	if reply.SeqNum != 0 || reply.Certificate != nil {
+		t.Fatalf("Stale request of the correct client was committed (%d)!", reply.SeqNum)
+	}
+	if count := countClientRequests(cfg, CLIENT); count != iters+rounds {
+		t.Fatalf("Committed %d requests as the correct client, expected %d!", count, iters+rounds)
+	}
+}
+
+func TestLinearizabilityChecker(t *testing.T) {
+	fmt.Println("Test: Linearizability Checker - Concurrent Histories")
+
+	at := func(ms int) time.Time { return time.Unix(0, int64(ms)*int64(time.Millisecond)) }
+	put := func(client int, value string, call int, ret int) HistoryEntry {
+		return HistoryEntry{client, KVOp{PUT, "x", value}, value, at(call), at(ret), true}
+	}
+	get := func(client int, value string, call int, ret int) HistoryEntry {
+		return HistoryEntry{client, KVOp{GET, "x", ""}, value, at(call), at(ret), true}
+	}
+
+	// Concurrent writes may take effect in either order
+	history := []HistoryEntry{put(1, "1", 0, 10), put(2, "2", 5, 15), get(3, "1", 20, 30)}
+	if CheckLinearizable(KVModel, history) == false {
+		t.Fatal("Linearizable history rejected!")
+	}
+
+	// A read can't observe a value overwritten before it was invoked
+	history = []HistoryEntry{put(1, "1", 0, 10), put(2, "2", 12, 15), get(3, "1", 20, 30)}
+	if CheckLinearizable(KVModel, history) == true {
+		t.Fatal("Stale read accepted!")
+	}
+
+	// A write with an unknown result may or may not have taken effect
+	lost := HistoryEntry{2, KVOp{PUT, "x", "2"}, nil, at(12), time.Time{}, false}
+	history = []HistoryEntry{put(1, "1", 0, 10), lost, get(3, "1", 20, 30), get(3, "2", 40, 50)}
+	if CheckLinearizable(KVModel, history) == false {
+		t.Fatal("Linearizable history with an unknown result rejected!")
+	}
+	history = append(history, get(3, "1", 60, 70))
+	if CheckLinearizable(KVModel, history) == true {
+		t.Fatal("Read of an overwritten value accepted!")
+	}
+
+	// Keys are independent in a key-value store, but not in a register
+	other := HistoryEntry{4, KVOp{GET, "y", ""}, "", at(20), at(30), true}
+	history = []HistoryEntry{put(1, "1", 0, 10), other}
+	if CheckLinearizable(KVModel, history) == false {
+		t.Fatal("Linearizable key-value history rejected!")
+	}
+	if CheckLinearizable(RegisterModel, history) == true {
+		t.Fatal("Non-linearizable register history accepted!")
+	}
+}
+
+func TestCommitCertificate(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Commit Certificates (t>1)")
+
+	iters := 3
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+	}
+
+	xp := cfg.xpServers[1] // Leader of view 1
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	if len(xp.certifiedPrefix(xp.commitLog, 2, xp.id)) != iters {
+		t.Fatal("Committed requests without a complete commit certificate!")
+	}
+
+	commitEntry := xp.commitLog[1]
+	if _, reason := xp.checkCommitCertificate(0, commitEntry, 2); reason == "" {
+		t.Fatal("Commit certificate moved to another sequence number not detected!")
+	}
+	if _, reason := xp.checkCommitCertificate(1, commitEntry, 1); reason == "" {
+		t.Fatal("Commit certificate of the current view not detected!")
+	}
+
+	// Check a copy of the entry without (or with a forged) commit message from each follower
+	for server, msg := range commitEntry.Msg1 {
+		entry := commitEntry
+		entry.Msg1 = make(map[int]Message, 0)
+		for follower, msg := range commitEntry.Msg1 {
+			if follower != server {
+				entry.Msg1[follower] = msg
+			}
+		}
+		if complete, reason := xp.checkCommitCertificate(1, entry, 2); complete == true || reason != "" {
+			t.Fatal("Missing commit message not detected!")
+		}
+
+		msg.Signature = forgeSignature(cfg.privateKeys[server], digest("forged"))
+		entry.Msg1[server] = msg
+		if _, reason := xp.checkCommitCertificate(1, entry, 2); reason == "" {
+			t.Fatal("Forged commit message not detected!")
+		}
+	}
+}
+
+func TestCertificateExport(t *testing.T) {
+	servers := 6
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Exported Commit Certificates (t>1)")
+
+	proposeWithViewChanges(cfg, 2, 2)
+
+	xp := cfg.xpServers[cfg.xpServers[1].leaderOf(getCurrentView(cfg))]
+	log := xp.ExportCertificates()
+	if len(log.Certificates) == 0 || log.Certificates[0].View == log.Certificates[len(log.Certificates)-1].View {
+		t.Fatal("Exported log does not span view changes!")
+	}
+
+	// Logs are checked once written and read back as JSON (indented, so that requests are too)
+	reload := func(log CertificateLog) CertificateLog {
+		data, err := json.MarshalIndent(log, "", "  ")
+		if err != nil {
+			t.Fatalf("Exported log can't be encoded (%v)!", err)
+		}
+		reloaded := CertificateLog{}
+		if err := json.Unmarshal(data, &reloaded); err != nil {
+			t.Fatalf("Exported log can't be decoded (%v)!", err)
+		}
+		return reloaded
+	}
+
+	// The cluster's published keys (i.e. what an auditor trusts)
+	trustedKeys := make(map[int]*rsa.PublicKey, servers-1)
+	for server := 1; server < servers; server++ {
+		trustedKeys[server] = cfg.publicKeys[server]
+	}
+
+	if err := VerifyCertificates(reload(log), nil); err != nil {
+		t.Fatalf("Valid exported log rejected (%v)!", err)
+	}
+	if err := VerifyCertificates(reload(log), trustedKeys); err != nil {
+		t.Fatalf("Valid exported log rejected with trusted keys (%v)!", err)
+	}
+
+	last := len(log.Certificates) - 1
+	alterations := []func(log *CertificateLog){
+		func(log *CertificateLog) { log.Certificates[0].Request = json.RawMessage(`{"forged":true}`) },
+		func(log *CertificateLog) { log.Certificates = append(log.Certificates[:1], log.Certificates[2:]...) },
+		func(log *CertificateLog) { log.Certificates[last].SeqNum++ },
+		func(log *CertificateLog) { log.Certificates[last].View++ },
+		func(log *CertificateLog) {
+			for server, _ := range log.Certificates[last].Commits {
+				delete(log.Certificates[last].Commits, server)
+				return
+			}
+		},
+		func(log *CertificateLog) {
+			leader := log.Certificates[0].Prepare.SenderId
+			log.PublicKeys[leader] = log.PublicKeys[leader%(servers-1)+1] // Another XPaxos server's key
+		},
+		func(log *CertificateLog) { log.Replicas = 1 << 40 }}
+
+	for i, alter := range alterations {
+		altered := reload(log)
+		alter(&altered)
+		if err := VerifyCertificates(reload(altered), nil); err == nil {
+			t.Fatalf("Altered exported log (%d) accepted!", i)
+		}
+		if err := VerifyCertificates(reload(altered), trustedKeys); err == nil {
+			t.Fatalf("Altered exported log (%d) accepted with trusted keys!", i)
+		}
+	}
+
+	// A log can't shrink the synchronous groups to the XPaxos servers whose keys it holds
+	shrunk := reload(log)
+	shrunk.Replicas = 2
+	shrunk.PublicKeys = map[int]*rsa.PublicKey{1: shrunk.PublicKeys[1]}
+	if err := VerifyCertificates(reload(shrunk), trustedKeys); err == nil {
+		t.Fatal("Shrunk exported log accepted with trusted keys!")
+	}
+}
+
+func TestViewChangeSignatures(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: View-Change Signatures (t=1)")
+
+	iters := 2
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+	}
+
+	xp := cfg.xpServers[1]
+	xp.mu.Lock()
+	commitLog := xp.commitLog
+	xp.mu.Unlock()
+
+	if commitLogDigest(nil) != commitLogDigest([]CommitLogEntry{}) {
+		t.Fatal("Digest of an empty commit log depends on its encoding!")
+	}
+
+	// XPaxos server (ID = 2) signs a suspect and a view-change message for view 2
+	suspectDigest := viewDigest(SUSPECT, 2, 2, [32]byte{})
+	vcDigest := viewDigest(VIEWCHANGE, 2, 2, commitLogDigest(commitLog))
+	vc := ViewChangeMessage{
+		MsgType:   VIEWCHANGE,
+		MsgDigest: vcDigest,
+		Signature: cfg.xpServers[2].sign(vcDigest),
+		View:      2,
+		SenderId:  2,
+		CommitLog: commitLog}
+
+	if xp.verifyVCSet(2, map[[32]byte]ViewChangeMessage{digest(vc): vc}) == false {
+		t.Fatal("Valid view-change message rejected!")
+	}
+
+	replayed := vc // Suspect signature replayed as a view-change message
+	replayed.MsgDigest = suspectDigest
+	replayed.Signature = cfg.xpServers[2].sign(suspectDigest)
+	altered := vc // Commit log replaced under the original signature
+	altered.CommitLog = commitLog[:1]
+	impersonated := vc // View-change message of another sender
+	impersonated.SenderId = 3
+
+	for _, msg := range []ViewChangeMessage{replayed, altered, impersonated} {
+		if xp.verifyVCSet(2, map[[32]byte]ViewChangeMessage{digest(msg): msg}) == true {
+			t.Fatal("Invalid view-change message accepted!")
+		}
+	}
+	if xp.verifyVCSet(3, map[[32]byte]ViewChangeMessage{digest(vc): vc}) == true {
+		t.Fatal("View-change message of another view accepted!")
+	}
+
+	vcSet := map[[32]byte]ViewChangeMessage{digest(vc): vc}
+	if vcSetDigest(vcSet) != vcSetDigest(map[[32]byte]ViewChangeMessage{[32]byte{}: vc}) {
+		t.Fatal("Digest of a VC-final message depends on its keys!")
+	}
+}
+
+func TestReplySignatures(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Reply Signatures (t=1)")
+
+	cfg.client.Propose(nil)
+
+	xp := cfg.xpServers[1]
+	request := ClientRequest{MsgType: REPLICATE, Timestamp: 0, ClientId: CLIENT}
+	reply := &Reply{MsgDigest: digest(request), SeqNum: 1, Success: true, Result: "result"}
+	cfg.xpServers[2].signReply(reply)
+
+	if xp.verifyReply(2, reply) == false {
+		t.Fatal("Valid reply rejected!")
+	}
+	if xp.verifyReply(3, reply) == true {
+		t.Fatal("Reply of another sender accepted!")
+	}
+
+	alterations := []func(reply *Reply){
+		func(reply *Reply) { reply.MsgDigest = [32]byte{} },
+		func(reply *Reply) { reply.SeqNum++ },
+		func(reply *Reply) { reply.View++ },
+		func(reply *Reply) { reply.SenderId = 3 },
+		func(reply *Reply) { reply.Success = false },
+		func(reply *Reply) { reply.IsLeader = true },
+		func(reply *Reply) { reply.Suspicious = true },
+		func(reply *Reply) { reply.Result = "altered" }}
+
+	for i, alter := range alterations {
+		altered := *reply
+		alter(&altered)
+		if xp.verifyReply(2, &altered) == true || xp.verifyReply(altered.SenderId, &altered) == true {
+			t.Fatalf("Altered reply (%d) accepted!", i)
+		}
+	}
+}
+
+// Replies with a reply signed beforehand, so that signatures can be checked after a trip through
+// the network
+type ReplyRelay struct {
+	reply Reply
+}
+
+func (rr *ReplyRelay) Relay(args int, reply *Reply) {
+	*reply = rr.reply
+}
+
+func TestEmptyReplySignatures(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Reply Signatures - Empty Results and Certificates")
+
+	net := network.MakeNetwork()
+	defer net.Cleanup()
+
+	relay := &ReplyRelay{}
+	srv := network.MakeServer()
+	srv.AddService(network.MakeService(relay))
+	net.AddServer(2, srv)
+	end := net.MakeEnd("relay")
+	net.Connect("relay", 2)
+	net.Enable("relay", true)
+
+	certificate := &Certificate{Request: json.RawMessage{}, Prepare: Message{Signature: []byte{}},
+		Commits: map[int]Message{}}
+
+	for i, result := range []interface{}{[]byte{}, nil} {
+		relay.reply = Reply{SeqNum: 1, Success: true, Result: result, Certificate: certificate}
+		cfg.xpServers[2].signReply(&relay.reply)
+
+		reply := &Reply{}
+		if end.Call("ReplyRelay.Relay", 0, reply, 1) == false {
+			t.Fatal("Reply lost!")
+		}
+		if cfg.xpServers[1].verifyReply(2, reply) == false {
+			t.Fatalf("Valid reply (%d) rejected after a trip through the network!", i)
+		}
+	}
+}
+
+func TestCommitProof(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Client Commit Proofs (t=1)")
+
+	if _, ok := cfg.client.Propose(nil); ok == false {
+		t.Fatal("Client got no commit proof!")
+	}
+
+	leader := cfg.xpServers[1]
+	leader.mu.Lock()
+	certificate := leader.certificate(0)
+	leader.mu.Unlock()
+	if certificate == nil {
+		t.Fatal("Leader has no commit certificate!")
+	}
+
+	request := ClientRequest{MsgType: REPLICATE, Timestamp: 0, ClientId: CLIENT}
+	makeReply := func(server int, view int, result interface{}) *Reply { // Signatures are checked on receipt
+		return &Reply{MsgDigest: digest(request), SeqNum: 1, View: view, SenderId: server, Success: true, Result: result}
+	}
+
+	// Replies carrying the request's commit certificate
+	reply := makeReply(1, 1, nil)
+	reply.Certificate = certificate
+	if cfg.client.commitProof(reply, make(map[[32]byte]map[int]bool, 0)) == false {
+		t.Fatal("Valid commit certificate rejected!")
+	}
+
+	alterations := []func(reply *Reply){
+		func(reply *Reply) { reply.MsgDigest = [32]byte{} },
+		func(reply *Reply) { reply.SeqNum++ },
+		func(reply *Reply) { reply.Certificate.Request = json.RawMessage(`{"forged":true}`) },
+		func(reply *Reply) { reply.Certificate.View++ },
+		func(reply *Reply) {
+			for server, _ := range reply.Certificate.Commits {
+				delete(reply.Certificate.Commits, server)
+				return
+			}
+		}}
+
+	for i, alter := range alterations {
+		altered, commits := *reply, make(map[int]Message, 0)
+		for server, msg := range certificate.Commits {
+			commits[server] = msg
+		}
+		altered.Certificate = &Certificate{certificate.SeqNum, certificate.View, certificate.Request,
+			certificate.Prepare, commits}
+		alter(&altered)
+		if cfg.client.commitProof(&altered, make(map[[32]byte]map[int]bool, 0)) == true {
+			t.Fatalf("Altered commit certificate (%d) accepted!", i)
+		}
+	}
+
+	// A certificate for the request proves that another one with the same timestamp was superseded
+	other := ClientRequest{MsgType: REPLICATE, Timestamp: 0, Operation: "other", ClientId: CLIENT}
+	reply.MsgDigest = digest(other)
+	if cfg.client.commitProof(reply, make(map[[32]byte]map[int]bool, 0)) == true ||
+		cfg.client.superseded(reply, other) == false {
+		t.Fatal("Commit certificate of another request accepted!")
+	}
+	other.Timestamp++
+	if cfg.client.superseded(reply, other) == true {
+		t.Fatal("Request with another timestamp superseded!")
+	}
+
+	// XPaxos servers only report the result of the request applied with a timestamp, but still
+	// send its commit certificate
+	other.Timestamp--
+	leader.mu.Lock()
+	_, seqNum, executed := leader.getClientResult(other)
+	otherCertificate := leader.getClientCertificate(other)
+	leader.mu.Unlock()
+	if seqNum != 0 || executed == false {
+		t.Fatal("Result of another request with the same timestamp reported!")
+	}
+	if otherCertificate == nil || otherCertificate.SeqNum != certificate.SeqNum {
+		t.Fatal("No commit certificate for another request with the same timestamp!")
+	}
+
+	// Matching replies from every member of a synchronous group
+	view := 2
+	group := leader.groupOf(view)
+	replies := make(map[[32]byte]map[int]bool, 0)
+	for server := 1; server < servers; server++ {
+		if group[server] == false && cfg.client.commitProof(makeReply(server, view, nil), replies) == true {
+			t.Fatal("Reply from outside the synchronous group accepted!")
+		}
+	}
+
+	if cfg.client.commitProof(makeReply(leader.leaderOf(view), view, "altered"), replies) == true {
+		t.Fatal("Reply with a different result accepted!")
+	}
+
+	members := 0
+	for server, _ := range group {
+		members++
+		if cfg.client.commitProof(makeReply(server, view, nil), replies) != (members == len(group)) {
+			t.Fatalf("Commit proof from (%d) of (%d) synchronous group members!", members, len(group))
+		}
+	}
+}
+
+func TestConfirmVC(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: View-Change Confirmations (t=1)")
+
+	cfg.client.Propose(nil)
+	cfg.client.Propose(nil)
+
+	// XPaxos server (ID = 1) is the leader of view 1
+	xp := cfg.xpServers[1]
+	xp.mu.Lock()
+	msg := xp.makeConfirmVC()
+	xp.mu.Unlock()
+
+	if committed(msg.Timestamps, 0) == false || committed(msg.Timestamps, 1) == false || committed(msg.Timestamps, 2) == true {
+		t.Fatal("Invalid committed timestamps!")
+	}
+	if cfg.client.verifyConfirmVC(msg) == false {
+		t.Fatal("Valid confirmation rejected!")
+	}
+
+	alterations := []func(msg *ConfirmVCMessage){
+		func(msg *ConfirmVCMessage) { msg.MsgType = NEWVIEW },
+		func(msg *ConfirmVCMessage) { msg.Timestamps = append(msg.Timestamps, 2) },
+		func(msg *ConfirmVCMessage) { msg.Timestamps = msg.Timestamps[:1] },
+		func(msg *ConfirmVCMessage) { msg.View++ },
+		func(msg *ConfirmVCMessage) { msg.SenderId = 2 },
+		func(msg *ConfirmVCMessage) {
+			msg.Signature = forgeSignature(cfg.privateKeys[2], msg.MsgDigest) // Not the leader
+		}}
+
+	for i, alter := range alterations {
+		altered := msg
+		altered.Timestamps = append([]int{}, msg.Timestamps...)
+		alter(&altered)
+		altered.MsgDigest = viewDigest(CONFIRMVC, altered.View, altered.SenderId, timestampsDigest(altered.Timestamps))
+		if cfg.client.verifyConfirmVC(altered) == true {
+			t.Fatalf("Altered confirmation (%d) accepted!", i)
+		}
+	}
+
+	// Outstanding requests are only confirmed by newer views
+	confirmCh := make(chan ConfirmVCMessage, 1)
+	cfg.client.mu.Lock()
+	cfg.client.outstanding[2] = confirmCh
+	cfg.client.mu.Unlock()
+
+	cfg.client.ConfirmVC(msg, &Reply{})
+	if confirmed := <-confirmCh; confirmed.View != 1 {
+		t.Fatal("Outstanding request not confirmed!")
+	}
+	cfg.client.ConfirmVC(msg, &Reply{})
+	if len(confirmCh) != 0 {
+		t.Fatal("Replayed confirmation accepted!")
+	}
+}
+
+func TestMisbehaviourProof(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Proof of Misbehaviour (t=1)")
+
+	xp := cfg.xpServers[2]
+
+	// XPaxos server (ID = 1) is the leader of view 1 and signs two prepares for sequence number 1
+	prepare := func(leader int, view int, operation string) Message {
+		request := ClientRequest{MsgType: REPLICATE, Timestamp: 0, Operation: operation, ClientId: CLIENT}
+		msg := Message{MsgType: PREPARE, MsgDigest: digest(request), PrepareSeqNum: 1, View: view, SenderId: leader}
+		msg.Signature = forgeSignature(cfg.privateKeys[leader], prepareDigest(msg))
+		return msg
+	}
+
+	proof := MisbehaviourProof{1, prepare(1, 1, "a"), prepare(1, 1, "b")}
+	if xp.verifyProof(proof) == false {
+		t.Fatal("Valid proof of misbehaviour rejected!")
+	}
+
+	sameRequest := proof
+	sameRequest.Prepare2 = prepare(1, 1, "a")
+	otherSeqNum := proof
+	otherSeqNum.Prepare2.PrepareSeqNum = 2
+	otherSeqNum.Prepare2.Signature = forgeSignature(cfg.privateKeys[1], prepareDigest(otherSeqNum.Prepare2))
+	notLeader := MisbehaviourProof{2, prepare(2, 1, "a"), prepare(2, 1, "b")}
+	forged := proof
+	forged.Prepare2.Signature = forgeSignature(cfg.privateKeys[2], prepareDigest(forged.Prepare2))
+	blamed := proof
+	blamed.ReplicaId = 2
+
+	for i, proof := range []MisbehaviourProof{sameRequest, otherSeqNum, notLeader, forged, blamed} {
+		if xp.verifyProof(proof) == true {
+			t.Fatalf("Invalid proof of misbehaviour (%d) accepted!", i)
+		}
+	}
+
+	// A commit for view 1 carrying the leader's other prepare convicts the leader
+	commit := proof.Prepare2
+	commit.MsgType = COMMIT
+	commit.SenderId = 3
+	commit.PrepareSignature = proof.Prepare2.Signature
+
+	xp.mu.Lock()
+	xp.prepareLog = append(xp.prepareLog, PrepareLogEntry{Msg0: proof.Prepare1})
+	xp.checkPrepare(xp.committedPrepare(commit))
+	xp.mu.Unlock()
+
+	if proofs := xp.MisbehaviourProofs(); len(proofs) != 1 || xp.verifyProof(proofs[0]) == false {
+		t.Fatal("Conflicting prepares not proven!")
+	}
+}
+
+func TestReissuedPrepares(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Re-Issued Prepare Signatures (t=1)")
+
+	iters := 2
+	for i := 0; i < iters; i++ {
+		cfg.client.Propose(nil)
+	}
+
+	// XPaxos server (ID = 2) fails to send RPCs 100% of the time, so the new leader re-issues the
+	// prepares of the requests committed in view 1
+	cfg.net.SetFaultRate(2, 100)
+	cfg.client.Propose(nil)
+	cfg.net.SetFaultRate(2, 0)
+
+	currentView := getCurrentView(cfg)
+	if currentView == 1 {
+		t.Fatal("No view change!")
+	}
+
+	for _, xp := range cfg.xpServers[1:] {
+		xp.mu.Lock()
+		if xp.view == currentView && xp.vcInProgress == false && xp.synchronousGroup[xp.id] == true {
+			for seqNum, prepareEntry := range xp.prepareLog[:iters] {
+				msg0 := prepareEntry.Msg0
+				if msg0.View != currentView || msg0.PrepareSeqNum != seqNum+1 || msg0.SenderId != xp.leaderOf(currentView) ||
+					xp.verify(msg0.SenderId, prepareDigest(msg0), msg0.Signature) == false {
+					xp.mu.Unlock()
+					t.Fatalf("Invalid re-issued prepare (%d) at XPaxos server (%d)!", seqNum+1, xp.id)
+				}
+
+				// A conflicting prepare of the new leader for the same sequence number convicts it
+				other := Message{MsgType: PREPARE, MsgDigest: digest("other"), PrepareSeqNum: seqNum + 1,
+					View: currentView, SenderId: msg0.SenderId}
+				other.Signature = forgeSignature(cfg.privateKeys[msg0.SenderId], prepareDigest(other))
+				if xp.verifyProof(MisbehaviourProof{msg0.SenderId, msg0, other}) == false {
+					xp.mu.Unlock()
+					t.Fatalf("Proof against a re-issued prepare (%d) rejected!", seqNum+1)
+				}
+			}
+		}
+		xp.mu.Unlock()
+	}
+}
+
+func TestRefusedPendingRequests(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Refused Pending Requests (t=1)")
+
+	cfg.client.Propose(nil)
+
+	// A faulty leader of an earlier view prepared an invalid request, then a valid one after it;
+	// neither could have been executed, so neither is prepared in the new view
+	view := getCurrentView(cfg)
+	xp := cfg.xpServers[cfg.xpServers[1].leaderOf(view)]
+	invalid := ClientRequest{MsgType: REPLICATE, Timestamp: -1, Operation: "a", ClientId: CLIENT}
+	valid := ClientRequest{MsgType: REPLICATE, Timestamp: WINDOW / 2, Operation: "b", ClientId: CLIENT}
+
+	xp.mu.Lock()
+	prepareSeqNum := xp.prepareSeqNum
+	xp.pending = []ClientRequest{invalid, valid}
+	xp.mu.Unlock()
+
+	xp.preparePending(view)
+
+	xp.mu.Lock()
+	if len(xp.pending) != 0 || xp.prepareSeqNum != prepareSeqNum {
+		xp.mu.Unlock()
+		t.Fatalf("Pending requests prepared at other sequence numbers (%d pending, prepare sequence number %d)!",
+			len(xp.pending), xp.prepareSeqNum)
+	}
+	xp.mu.Unlock()
+
+	cfg.client.Propose(nil)
+
+	comparePrepareSeqNums(cfg)
+	compareExecuteSeqNums(cfg)
+	comparePrepareLogEntries(cfg)
+	compareCommitLogEntries(cfg)
+}
+
+func TestSafetyMonitor(t *testing.T) {
+	fmt.Println("Test: Safety Monitor - Conflicting Executions and Lost Requests")
+
+	violations := 0
+	m := MakeMonitor(func(violation string) { violations++ })
+
+	entry := func(timestamp int) CommitLogEntry {
+		return CommitLogEntry{Request: ClientRequest{MsgType: REPLICATE, Timestamp: timestamp, ClientId: CLIENT}}
+	}
+
+	m.execute(1, 1, 0, entry(0))
+	m.execute(2, 1, 0, entry(0))
+	m.execute(1, 1, 1, entry(1))
+	m.newView(2, 2, []CommitLogEntry{entry(0), entry(1), entry(2)}, nil)
+	if violations != 0 {
+		t.Fatalf("Safe executions reported (%v)!", m.Violations())
+	}
+
+	m.execute(3, 2, 1, entry(2)) // Different request at the same sequence number in a later view
+	if violations != 1 {
+		t.Fatal("Conflicting execution not reported!")
+	}
+
+	m.newView(3, 3, []CommitLogEntry{entry(0)}, nil) // Executed request lost
+	m.newView(1, 3, []CommitLogEntry{entry(0), entry(2)}, nil)
+	if violations != 3 {
+		t.Fatal("Lost or replaced request not reported!")
+	}
+
+	m.newView(2, 3, []CommitLogEntry{entry(0)}, []ClientRequest{entry(1).Request}) // Prepared again first
+	if violations != 3 {
+		t.Fatalf("Request prepared again in the new view reported (%v)!", m.Violations())
+	}
+
+	m.commit(1, 3, 5, entry(5))
+	m.commit(2, 3, 5, entry(5))
+	if violations != 3 {
+		t.Fatalf("Safe commits reported (%v)!", m.Violations())
+	}
+	m.commit(3, 4, 5, entry(6)) // Divergent commits, neither of them executed
+	if violations != 4 {
+		t.Fatal("Divergent commit not reported!")
+	}
+	m.commit(2, 3, 0, entry(3)) // Commit conflicting with an executed request
+	if violations != 5 {
+		t.Fatal("Commit conflicting with an execution not reported!")
+	}
+
+	m.stop()
+	m.execute(1, 3, 0, entry(1))
+	if violations != 5 || len(m.Violations()) != 6 {
+		t.Fatal("Violation reported after the monitor stopped!")
+	}
+}
+
+func TestSafetyMonitorLostCommit(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Safety Monitor - Committed Request Lost by a Forged New View (t=1)")
+
+	violations := make([]string, 0)
+	var mu sync.Mutex
+	m := MakeMonitor(func(violation string) {
+		mu.Lock()
+		defer mu.Unlock()
+		violations = append(violations, violation)
+	})
+	for _, xp := range cfg.xpServers[1:] {
+		xp.SetMonitor(m)
+	}
+
+	group := cfg.xpServers[1].groupOf(1)
+	leader := cfg.xpServers[1].leaderOf(1)
+
+	// The follower of view 1 commits a request that it never executes
+	follower := 0
+	for server, _ := range group {
+		if server != leader {
+			follower = server
+		}
+	}
+	xp := cfg.xpServers[follower]
+
+	request := ClientRequest{MsgType: REPLICATE, Timestamp: 1, Operation: nil, ClientId: CLIENT}
+	msg0 := Message{MsgType: PREPARE, MsgDigest: digest(request), PrepareSeqNum: 1, View: 1, SenderId: leader}
+	msg0.Signature = forgeSignature(cfg.privateKeys[leader], prepareDigest(msg0))
+	msg1 := Message{MsgType: COMMIT, MsgDigest: msg0.MsgDigest, PrepareSeqNum: 1, View: 1, SenderId: follower}
+	msg1.Signature = forgeSignature(cfg.privateKeys[follower], msg1.MsgDigest)
+
+	xp.mu.Lock()
+	xp.appendToCommitLog(request, msg0, map[int]Message{follower: msg1})
+	xp.checkCommitted(0)
+	xp.mu.Unlock()
+
+	if len(m.Violations()) != 0 {
+		t.Fatalf("Safe commit reported (%v)!", m.Violations())
+	}
+
+	// A replica outside the synchronous group of view 1 installs a later view from a forged new-view
+	// message of its (Byzantine) leader, whose commit log doesn't hold the committed request
+	other := 0
+	for server := 1; server < servers; server++ {
+		if group[server] == false {
+			other = server
+		}
+	}
+	xp = cfg.xpServers[other]
+
+	view := 2
+	for xp.groupOf(view)[other] == false {
+		view++
+	}
+	newLeader := xp.leaderOf(view)
+
+	xp.mu.Lock()
+	xp.view = view
+	xp.generateSynchronousGroup(int64(view))
+	xp.vcInProgress = true
+	xp.mu.Unlock()
+
+	msgDigest := viewDigest(NEWVIEW, view, newLeader, prepareLogDigest(nil))
+	msg := NewViewMessage{
+		MsgType:   NEWVIEW,
+		MsgDigest: msgDigest,
+		Signature: forgeSignature(cfg.privateKeys[newLeader], msgDigest),
+		View:      view,
+		SenderId:  newLeader}
+
+	reply := &Reply{}
+	xp.NewView(msg, reply)
+
+	if reply.Success == false {
+		t.Fatal("Forged new view not installed!")
+	}
+	if len(m.Violations()) != 1 {
+		t.Fatalf("Lost committed request not reported (%v)!", m.Violations())
+	}
+}
+
+func TestLinearizability1(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Linearizability - Key-Value Store (t=1)")
+
+	iters := 5
+	for i := 0; i < iters; i++ {
+		value := strconv.Itoa(i)
+		if result, ok := cfg.client.Propose(KVOp{PUT, "x", value}); ok == false || result != value {
+			t.Fatalf("Invalid result of Put (%v)!", result)
+		}
+		cfg.client.Propose(KVOp{APPEND, "y", value})
+		if result, ok := cfg.client.Propose(KVOp{GET, "y", ""}); ok == false || len(result.(string)) != i+1 {
+			t.Fatalf("Invalid result of Get (%v)!", result)
+		}
+		compareCommitLogEntries(cfg)
+	}
+
+	checkLinearizable(cfg, KVModel)
+}
+
+func TestLinearizability2(t *testing.T) {
+	servers := 4
+	cfg := makeConfig(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Println("Test: Linearizability - Crash and Partition Faults (t=1)")
+
+	s := cfg.makeSchedule()
+	s.AfterOps(4).Crash(1)
+	s.AfterOps(8).Recover(1).AfterOps(8).Partition("isolate-2", []int{2}, []int{1, 3})
+	s.AfterOps(12).Heal("isolate-2")
+	s.Start()
+	defer s.Stop()
+
+	iters := 16
+	for i := 0; i < iters; i++ {
+		key := string('a' + rune(i%2))
+		cfg.client.Propose(KVOp{APPEND, key, strconv.Itoa(i)})
+		cfg.client.Propose(KVOp{GET, key, ""})
+		s.Op()
+	}
+
+	compareCommitLogEntries(cfg)
+	checkLinearizable(cfg, KVModel)
+}
+
+func TestFuzzShrink(t *testing.T) {
+	fmt.Println("Test: Fuzz - Shrinking Failing Schedules")
+
+	fs, err := parseFuzzSchedule("seed=1 servers=6 ops=40; #3-#30 crash 1; #5-#25 byzantine 2 equivocate; " +
+		"#30-#40 link 3 4; #0-#12 delay 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := parseFuzzSchedule(fs.String()); err != nil || parsed.String() != fs.String() {
+		t.Fatalf("Invalid parsed schedule (%v, %v)!", parsed, err)
+	}
+
+	// Fails whenever server 2 equivocates during operation 10
+	fails := func(fs fuzzSchedule) bool {
+		for _, f := range fs.Faults {
+			if f.Kind == FUZZ_BYZANTINE && f.Server == 2 && f.Start <= 10 && 10 < f.End && fs.Ops > 10 {
+				return true
+			}
+		}
+		return false
+	}
+
+	shrunk := shrinkFuzzSchedule(fs, fails)
+	if expected := "seed=1 servers=6 ops=11; #10-#11 byzantine 2 equivocate"; shrunk.String() != expected {
+		t.Fatalf("Invalid shrunk schedule (%v)!", shrunk)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		fs := randomFuzzSchedule(r, 6, 20)
+		for op := 0; op < fs.Ops; op++ {
+			faulty := map[int]bool{}
+			for _, f := range fs.Faults {
+				if f.Start <= op && op < f.End {
+					faulty[f.Server] = true
+				}
+			}
+			if len(faulty) > fs.budget() {
+				t.Fatalf("Schedule exceeds the fault budget (%v)!", fs)
+			}
+		}
+	}
+}
+
+// By default the same FUZZRUNS schedules run every time (drawn from FUZZSEED); set
+// XPAXOS_FUZZ=<runs> to run that many fresh random schedules, or XPAXOS_FUZZ_SCHEDULE=<schedule>
+// to replay one (failing schedules are shrunk and logged)
+func TestFuzz(t *testing.T) {
+	fmt.Println("Test: Fuzz - Random Fault Schedules")
+
+	schedules := []fuzzSchedule{}
+	if text := os.Getenv("XPAXOS_FUZZ_SCHEDULE"); text != "" {
+		fs, err := parseFuzzSchedule(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schedules = append(schedules, fs)
+	} else {
+		runs, seed := FUZZRUNS, int64(FUZZSEED)
+		if text := os.Getenv("XPAXOS_FUZZ"); text != "" {
+			var err error
+			if runs, err = strconv.Atoi(text); err != nil {
+				t.Fatalf("Invalid XPAXOS_FUZZ (%v)!", err)
+			}
+			seed = time.Now().UnixNano()
+		}
+		r := rand.New(rand.NewSource(seed))
+		for i := 0; i < runs; i++ {
+			schedules = append(schedules, randomFuzzSchedule(r, 4+2*(i%2), 20))
+		}
+	}
+
+	for _, fs := range schedules {
+		t.Logf("Fuzz schedule (%v)", fs)
+		if failure := runFuzzSchedule(fs); failure != "" {
+			shrunk := shrinkFuzzSchedule(fs, func(fs fuzzSchedule) bool { return runFuzzSchedule(fs) != "" })
+			t.Fatalf("Fuzz run failed (%s); minimal schedule: XPAXOS_FUZZ_SCHEDULE=\"%v\"", failure, shrunk)
+		}
+	}
+}
+
+func TestFuzzIncompleteCertificate(t *testing.T) {
+	fmt.Println("Test: Fuzz - Request Committed by a Delayed Member (t>1)")
+
+	// XPaxos server (ID = 4) is a member of the synchronous group of view 1, and its links are
+	// slower than the group's timeouts: it is the only member with a complete certificate of the
+	// first request when the view changes, and the view-change quorum doesn't include it. The
+	// network is reliable and its latency constant, so the outcome doesn't depend on the seed
+	fs, err := parseFuzzSchedule("seed=1 servers=6 ops=1; #0-#1 delay 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failure := runFuzzSchedule(fs); failure != "" {
+		t.Fatalf("Fuzz run failed (%s); schedule: XPAXOS_FUZZ_SCHEDULE=\"%v\"", failure, fs)
+	}
+}
+
+//
+// ---------------------------- BENCHMARK FUNCTIONS ---------------------------
+//
+func benchmarkNoFaults(n int, size int, b *testing.B) {
+	servers := n // The number of XPaxos servers is n-1 (client included!)
+	cfg := makeConfig(nil, servers, false)
+	defer cfg.cleanup()
+
+	op := make([]byte, size)
+	rand.Read(op) // Operation is random byte array of size bytes
+
+	cfg.net.ResetStats()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg.client.Propose(op)
+	}
+	b.ReportMetric(float64(cfg.net.Stats().Total().Bytes)/float64(b.N), "bytes/op") // Bytes per commit
+}
+
+func benchmarkWAN(n int, size int, path string, b *testing.B) {
+	servers := n // The number of XPaxos servers is n-1 (client included!)
+	cfg := makeConfig(nil, servers, false)
+	defer cfg.cleanup()
+
+	if err := cfg.net.LoadLatencyMatrix(path); err != nil {
+		b.Fatal(err)
+	}
+
+	op := make([]byte, size)
+	rand.Read(op) // Operation is random byte array of size bytes
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg.client.Propose(op)
+	}
+}
+
+func benchmarkBandwidth(n int, size int, bps int64, b *testing.B) {
+	servers := n // The number of XPaxos servers is n-1 (client included!)
+	cfg := makeConfig(nil, servers, false)
+	defer cfg.cleanup()
+
+	cfg.net.SetBandwidthAll(bps, bps)
+
+	op := make([]byte, size)
+	rand.Read(op) // Operation is random byte array of size bytes
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg.client.Propose(op)
+	}
+}
+
+func benchmarkRandomCrashFaults1(n int, size int, b *testing.B) {
+	servers := n // The number of XPaxos servers is n-1 (client included!)
+	cfg := makeConfig(nil, servers, false)
+	defer cfg.cleanup()
+
+	s := cfg.makeSchedule() // Move the crash fault to a random server after every operation
+	crash := cfg.rand.Intn(servers-1) + 1
+	s.AfterOps(0).Crash(crash)
+	for i := 1; i <= b.N; i++ {
+		s.AfterOps(i).Recover(crash)
+		crash = cfg.rand.Intn(servers-1) + 1
+		s.AfterOps(i).Crash(crash)
+	}
+	s.Start()
+	defer s.Stop()
+
+	op := make([]byte, size)
+	rand.Read(op) // Operation is random byte array of size bytes
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg.client.Propose(op)
+		s.Op()
+	}
+}
+
+func benchmarkRandomCrashFaults2(n int, size int, b *testing.B) {
+	servers := n // The number of XPaxos servers is n-1 (client included!)
+	cfg := makeConfig(nil, servers, false)
+	defer cfg.cleanup()
+
+	s := cfg.makeSchedule() // Move both crash faults to random servers after every operation
+	crash1 := cfg.rand.Intn(servers-1) + 1
+	crash2 := cfg.rand.Intn(servers-1) + 1
+	s.AfterOps(0).Crash(crash1).AfterOps(0).Crash(crash2)
+	for i := 1; i <= b.N; i++ {
+		s.AfterOps(i).Recover(crash1).AfterOps(i).Recover(crash2)
+		crash1 = cfg.rand.Intn(servers-1) + 1
+		crash2 = cfg.rand.Intn(servers-1) + 1
+		s.AfterOps(i).Crash(crash1).AfterOps(i).Crash(crash2)
+	}
+	s.Start()
+	defer s.Stop()
+
+	op := make([]byte, size)
+	rand.Read(op) // Operation is random byte array of size bytes
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg.client.Propose(op)
+		s.Op()
+	}
+}
+
+func benchmarkByzantineFault(n int, size int, b *testing.B) {
+	servers := n // The number of XPaxos servers is n-1 (client included!)
+	cfg := makeConfig(nil, servers, false)
+	defer cfg.cleanup()
+
+	s := cfg.makeSchedule() // Move the Byzantine fault to a random server after every operation
+	fault := cfg.rand.Intn(servers-1) + 1
+	s.AfterOps(0).Byzantine(fault)
+	for i := 1; i <= b.N; i++ {
+		s.AfterOps(i).Honest(fault)
+		fault = cfg.rand.Intn(servers-1) + 1
+		s.AfterOps(i).Byzantine(fault)
+	}
+	s.Start()
+	defer s.Stop()
+
+	op := make([]byte, size)
+	rand.Read(op) // Operation is random byte array of size bytes
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg.client.Propose(op)
+		s.Op()
+	}
+}
+
+// Benchmark_3_0 - Number of XPaxos servers = 3 (t=1), No Faults
+func Benchmark_3_0_1kB(b *testing.B)   { benchmarkNoFaults(4, 1024, b) }
+func Benchmark_3_0_2kB(b *testing.B)   { benchmarkNoFaults(4, 2048, b) }
+func Benchmark_3_0_4kB(b *testing.B)   { benchmarkNoFaults(4, 4096, b) }
+func Benchmark_3_0_8kB(b *testing.B)   { benchmarkNoFaults(4, 8192, b) }
+func Benchmark_3_0_16kB(b *testing.B)  { benchmarkNoFaults(4, 16384, b) }
+func Benchmark_3_0_32kB(b *testing.B)  { benchmarkNoFaults(4, 32768, b) }
+func Benchmark_3_0_64kB(b *testing.B)  { benchmarkNoFaults(4, 65536, b) }
+func Benchmark_3_0_128kB(b *testing.B) { benchmarkNoFaults(4, 131072, b) }
+func Benchmark_3_0_256kB(b *testing.B) { benchmarkNoFaults(4, 262144, b) }
+func Benchmark_3_0_512kB(b *testing.B) { benchmarkNoFaults(4, 524288, b) }
+func Benchmark_3_0_1MB(b *testing.B)   { benchmarkNoFaults(4, 1048576, b) }
+func Benchmark_3_0_2MB(b *testing.B)   { benchmarkNoFaults(4, 2097152, b) }
+func Benchmark_3_0_4MB(b *testing.B)   { benchmarkNoFaults(4, 4194304, b) }
+func Benchmark_3_0_8MB(b *testing.B)   { benchmarkNoFaults(4, 8388608, b) }
+
+// Benchmark_5_0 - Number of XPaxos servers = 5 (t=2), No Faults
+func Benchmark_5_0_1kB(b *testing.B)   { benchmarkNoFaults(6, 1024, b) }
+func Benchmark_5_0_2kB(b *testing.B)   { benchmarkNoFaults(6, 2048, b) }
+func Benchmark_5_0_4kB(b *testing.B)   { benchmarkNoFaults(6, 4096, b) }
+func Benchmark_5_0_8kB(b *testing.B)   { benchmarkNoFaults(6, 8192, b) }
+func Benchmark_5_0_16kB(b *testing.B)  { benchmarkNoFaults(6, 16384, b) }
+func Benchmark_5_0_32kB(b *testing.B)  { benchmarkNoFaults(6, 32768, b) }
+func Benchmark_5_0_64kB(b *testing.B)  { benchmarkNoFaults(6, 65536, b) }
+func Benchmark_5_0_128kB(b *testing.B) { benchmarkNoFaults(6, 131072, b) }
+func Benchmark_5_0_256kB(b *testing.B) { benchmarkNoFaults(6, 262144, b) }
+func Benchmark_5_0_512kB(b *testing.B) { benchmarkNoFaults(6, 524288, b) }
+func Benchmark_5_0_1MB(b *testing.B)   { benchmarkNoFaults(6, 1048576, b) }
+func Benchmark_5_0_2MB(b *testing.B)   { benchmarkNoFaults(6, 2097152, b) }
+func Benchmark_5_0_4MB(b *testing.B)   { benchmarkNoFaults(6, 4194304, b) }
+func Benchmark_5_0_8MB(b *testing.B)   { benchmarkNoFaults(6, 8388608, b) }
+
+// Benchmark_11_0 - Number of XPaxos servers = 11 (t=5), No Faults
+func Benchmark_11_0_1kB(b *testing.B)   { benchmarkNoFaults(12, 1024, b) }
+func Benchmark_11_0_2kB(b *testing.B)   { benchmarkNoFaults(12, 2048, b) }
+func Benchmark_11_0_4kB(b *testing.B)   { benchmarkNoFaults(12, 4096, b) }
+func Benchmark_11_0_8kB(b *testing.B)   { benchmarkNoFaults(12, 8192, b) }
+func Benchmark_11_0_16kB(b *testing.B)  { benchmarkNoFaults(12, 16384, b) }
+func Benchmark_11_0_32kB(b *testing.B)  { benchmarkNoFaults(12, 32768, b) }
+func Benchmark_11_0_64kB(b *testing.B)  { benchmarkNoFaults(12, 65536, b) }
 func Benchmark_11_0_128kB(b *testing.B) { benchmarkNoFaults(12, 131072, b) }
 func Benchmark_11_0_256kB(b *testing.B) { benchmarkNoFaults(12, 262144, b) }
 func Benchmark_11_0_512kB(b *testing.B) { benchmarkNoFaults(12, 524288, b) }
@@ -617,6 +2219,49 @@ func Benchmark_11_0_2MB(b *testing.B)   { benchmarkNoFaults(12, 2097152, b) }
 func Benchmark_11_0_4MB(b *testing.B)   { benchmarkNoFaults(12, 4194304, b) }
 func Benchmark_11_0_8MB(b *testing.B)   { benchmarkNoFaults(12, 8388608, b) }
 
+// Benchmark_3_WAN - Number of XPaxos servers = 3 (t=1), No Faults, EC2 Latencies (see wan/ec2_3.txt)
+func Benchmark_3_WAN_1kB(b *testing.B)  { benchmarkWAN(4, 1024, "wan/ec2_3.txt", b) }
+func Benchmark_3_WAN_64kB(b *testing.B) { benchmarkWAN(4, 65536, "wan/ec2_3.txt", b) }
+func Benchmark_3_WAN_1MB(b *testing.B)  { benchmarkWAN(4, 1048576, "wan/ec2_3.txt", b) }
+
+// Benchmark_5_WAN - Number of XPaxos servers = 5 (t=2), No Faults, EC2 Latencies (see wan/ec2_5.txt)
+func Benchmark_5_WAN_1kB(b *testing.B)  { benchmarkWAN(6, 1024, "wan/ec2_5.txt", b) }
+func Benchmark_5_WAN_64kB(b *testing.B) { benchmarkWAN(6, 65536, "wan/ec2_5.txt", b) }
+func Benchmark_5_WAN_1MB(b *testing.B)  { benchmarkWAN(6, 1048576, "wan/ec2_5.txt", b) }
+
+// Benchmark_3_1G - Number of XPaxos servers = 3 (t=1), No Faults, 1 Gbps Uplinks and Downlinks
+func Benchmark_3_1G_1kB(b *testing.B)   { benchmarkBandwidth(4, 1024, network.Gbps, b) }
+func Benchmark_3_1G_2kB(b *testing.B)   { benchmarkBandwidth(4, 2048, network.Gbps, b) }
+func Benchmark_3_1G_4kB(b *testing.B)   { benchmarkBandwidth(4, 4096, network.Gbps, b) }
+func Benchmark_3_1G_8kB(b *testing.B)   { benchmarkBandwidth(4, 8192, network.Gbps, b) }
+func Benchmark_3_1G_16kB(b *testing.B)  { benchmarkBandwidth(4, 16384, network.Gbps, b) }
+func Benchmark_3_1G_32kB(b *testing.B)  { benchmarkBandwidth(4, 32768, network.Gbps, b) }
+func Benchmark_3_1G_64kB(b *testing.B)  { benchmarkBandwidth(4, 65536, network.Gbps, b) }
+func Benchmark_3_1G_128kB(b *testing.B) { benchmarkBandwidth(4, 131072, network.Gbps, b) }
+func Benchmark_3_1G_256kB(b *testing.B) { benchmarkBandwidth(4, 262144, network.Gbps, b) }
+func Benchmark_3_1G_512kB(b *testing.B) { benchmarkBandwidth(4, 524288, network.Gbps, b) }
+func Benchmark_3_1G_1MB(b *testing.B)   { benchmarkBandwidth(4, 1048576, network.Gbps, b) }
+func Benchmark_3_1G_2MB(b *testing.B)   { benchmarkBandwidth(4, 2097152, network.Gbps, b) }
+func Benchmark_3_1G_4MB(b *testing.B)   { benchmarkBandwidth(4, 4194304, network.Gbps, b) }
+func Benchmark_3_1G_8MB(b *testing.B)   { benchmarkBandwidth(4, 8388608, network.Gbps, b) }
+
+// Benchmark_5_1G - Number of XPaxos servers = 5 (t=2), No Faults, 1 Gbps Uplinks and Downlinks
+func Benchmark_5_1G_1kB(b *testing.B)   { benchmarkBandwidth(6, 1024, network.Gbps, b) }
+func Benchmark_5_1G_2kB(b *testing.B)   { benchmarkBandwidth(6, 2048, network.Gbps, b) }
+func Benchmark_5_1G_4kB(b *testing.B)   { benchmarkBandwidth(6, 4096, network.Gbps, b) }
+func Benchmark_5_1G_8kB(b *testing.B)   { benchmarkBandwidth(6, 8192, network.Gbps, b) }
+func Benchmark_5_1G_16kB(b *testing.B)  { benchmarkBandwidth(6, 16384, network.Gbps, b) }
+func Benchmark_5_1G_32kB(b *testing.B)  { benchmarkBandwidth(6, 32768, network.Gbps, b) }
+func Benchmark_5_1G_64kB(b *testing.B)  { benchmarkBandwidth(6, 65536, network.Gbps, b) }
+func Benchmark_5_1G_128kB(b *testing.B) { benchmarkBandwidth(6, 131072, network.Gbps, b) }
+func Benchmark_5_1G_256kB(b *testing.B) { benchmarkBandwidth(6, 262144, network.Gbps, b) }
+func Benchmark_5_1G_512kB(b *testing.B) { benchmarkBandwidth(6, 524288, network.Gbps, b) }
+func Benchmark_5_1G_1MB(b *testing.B)   { benchmarkBandwidth(6, 1048576, network.Gbps, b) }
+func Benchmark_5_1G_2MB(b *testing.B)   { benchmarkBandwidth(6, 2097152, network.Gbps, b) }
+func Benchmark_5_1G_4MB(b *testing.B)   { benchmarkBandwidth(6, 4194304, network.Gbps, b) }
+// => 8 MB operations exceed the synchronous group's 3*DELTA timeouts at 1 Gbps when t=2
+//func Benchmark_5_1G_8MB(b *testing.B)   { benchmarkBandwidth(6, 8388608, network.Gbps, b) }
+
 // Benchmark_3_R1 - Number of XPaxos servers = 3 (t=1), One Random Crash Fault
 //func Benchmark_3_R1_256kB(b *testing.B) { benchmarkRandomCrashFaults1(4, 262144, b) }
 
@@ -10,6 +10,8 @@ import (
 	"log"
 	"math/rand"
 	"network"
+	"reflect"
+	"sort"
 	"time"
 )
 
@@ -58,6 +60,10 @@ func (xp *XPaxos) sign(msgDigest [32]byte) []byte { // Crypto message signature
 }
 
 func (xp *XPaxos) verify(server int, msgDigest [32]byte, signature []byte) bool { // Crypto signature verification
+	if xp.publicKeys[server] == nil { // Unknown server
+		return false
+	}
+
 	err := rsa.VerifyPKCS1v15(xp.publicKeys[server], crypto.SHA256, msgDigest[:], signature)
 	if err != nil {
 		return false
@@ -65,30 +71,210 @@ func (xp *XPaxos) verify(server int, msgDigest [32]byte, signature []byte) bool
 	return true
 }
 
+// Digest signed in replies: it covers every field of the reply (the result and certificate through
+// their digests), so that a reply altered in transit fails verification
+func replyDigest(reply Reply) [32]byte {
+	return digest(struct {
+		MsgType           int
+		MsgDigest         [32]byte
+		SeqNum            int
+		View              int
+		SenderId          int
+		Success           bool
+		IsLeader          bool
+		Suspicious        bool
+		ResultDigest      [32]byte
+		CertificateDigest [32]byte
+	}{REPLY, reply.MsgDigest, reply.SeqNum, reply.View, reply.SenderId, reply.Success, reply.IsLeader,
+		reply.Suspicious, resultDigest(reply.Result), certificateDigest(reply.Certificate)})
+}
+
+// Sending a reply turns empty slices and maps into nil ones, so the result and certificate are
+// digested in a form that both encode to (as commitLogDigest does for commit logs)
+func resultDigest(result interface{}) [32]byte {
+	value := reflect.ValueOf(result)
+	if result == nil || ((value.Kind() == reflect.Slice || value.Kind() == reflect.Map) && value.Len() == 0) {
+		return digest(nil)
+	}
+	return digest(result)
+}
+
+func certificateDigest(certificate *Certificate) [32]byte {
+	if certificate == nil {
+		return digest(nil)
+	}
+
+	senders := make([]int, 0, len(certificate.Commits))
+	for senderId, _ := range certificate.Commits {
+		senders = append(senders, senderId)
+	}
+	sort.Ints(senders)
+
+	commitDigests := make([][32]byte, 0, len(senders))
+	for _, senderId := range senders {
+		commitDigests = append(commitDigests, messageDigest(certificate.Commits[senderId]))
+	}
+
+	return digest(struct {
+		SeqNum        int
+		View          int
+		RequestDigest [32]byte
+		PrepareDigest [32]byte
+		NumCommits    int
+		CommitDigests [][32]byte
+	}{certificate.SeqNum, certificate.View, sha256.Sum256(certificate.Request), messageDigest(certificate.Prepare),
+		len(commitDigests), commitDigests})
+}
+
+// Digest of every field of a message, with its signatures through their digests (the same whether
+// they are nil or empty)
+func messageDigest(msg Message) [32]byte {
+	return digest(struct {
+		MsgType                int
+		MsgDigest              [32]byte
+		SignatureDigest        [32]byte
+		PrepareSeqNum          int
+		View                   int
+		ClientTimestamp        int
+		SenderId               int
+		PrepareSignatureDigest [32]byte
+	}{msg.MsgType, msg.MsgDigest, sha256.Sum256(msg.Signature), msg.PrepareSeqNum, msg.View, msg.ClientTimestamp,
+		msg.SenderId, sha256.Sum256(msg.PrepareSignature)})
+}
+
+// Every RPC handler signs its reply on return (i.e. once its fields are final)
+func (xp *XPaxos) signReply(reply *Reply) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	reply.View = xp.view
+	reply.SenderId = xp.id
+	reply.Signature = xp.sign(replyDigest(*reply))
+}
+
+func (xp *XPaxos) verifyReply(server int, reply *Reply) bool {
+	return reply.SenderId == server && xp.verify(server, replyDigest(*reply), reply.Signature)
+}
+
+// Digest signed in prepare messages: it binds the request digest to the view and sequence number, so
+// that two prepares of the same leader for the same sequence number of a view prove it is faulty
+// if their requests differ (see misbehaviour.go)
+func prepareDigest(msg Message) [32]byte {
+	return digest(struct {
+		MsgType       int
+		MsgDigest     [32]byte
+		PrepareSeqNum int
+		View          int
+		SenderId      int
+	}{PREPARE, msg.MsgDigest, msg.PrepareSeqNum, msg.View, msg.SenderId})
+}
+
+// Digest signed by the operator in re-admission commands: every field but the signature
+func readmitDigest(op ReadmitOp) [32]byte {
+	return digest(ReadmitOp{ReplicaId: op.ReplicaId, Nonce: op.Nonce})
+}
+
+// Digest signed in view-change protocol messages (suspect, view-change, VC-final and new-view): it
+// binds the message type, view and sender to the payload, so that a signed message can't be
+// replayed as another message type, for another sender or with another payload
+func viewDigest(msgType int, view int, senderId int, payloadDigest [32]byte) [32]byte {
+	return digest(struct {
+		MsgType       int
+		View          int
+		SenderId      int
+		PayloadDigest [32]byte
+	}{msgType, view, senderId, payloadDigest})
+}
+
+// Payload digests must not depend on map order or on empty slices and maps being decoded as nil
+func commitLogDigest(commitLog []CommitLogEntry) [32]byte {
+	entryDigests := make([][32]byte, 0, len(commitLog))
+
+	for _, commitEntry := range commitLog {
+		senders := make([]int, 0, len(commitEntry.Msg1))
+		for senderId, _ := range commitEntry.Msg1 {
+			senders = append(senders, senderId)
+		}
+		sort.Ints(senders)
+
+		msg1 := make([]Message, 0, len(senders))
+		for _, senderId := range senders {
+			msg1 = append(msg1, commitEntry.Msg1[senderId])
+		}
+
+		entryDigests = append(entryDigests, digest(struct {
+			RequestDigest [32]byte
+			Msg0          Message
+			Msg1          []Message
+			View          int
+		}{digest(commitEntry.Request), commitEntry.Msg0, msg1, commitEntry.View}))
+	}
+	return digest(entryDigests)
+}
+
+func timestampsDigest(timestamps []int) [32]byte {
+	return digest(append(make([]int, 0, len(timestamps)), timestamps...))
+}
+
+func prepareLogDigest(prepareLog []PrepareLogEntry) [32]byte {
+	entryDigests := make([][32]byte, 0, len(prepareLog))
+
+	for _, prepareEntry := range prepareLog {
+		entryDigests = append(entryDigests, digest(struct {
+			RequestDigest [32]byte
+			Msg0          Message
+		}{digest(prepareEntry.Request), prepareEntry.Msg0}))
+	}
+	return digest(entryDigests)
+}
+
+// View-change messages are bound by their own (signed) digests
+func vcSetDigest(vcSet map[[32]byte]ViewChangeMessage) [32]byte {
+	msgDigests := make([][32]byte, 0, len(vcSet))
+	for _, msg := range vcSet {
+		msgDigests = append(msgDigests, msg.MsgDigest)
+	}
+
+	sort.Slice(msgDigests, func(i int, j int) bool {
+		return bytes.Compare(msgDigests[i][:], msgDigests[j][:]) < 0
+	})
+	return digest(msgDigests)
+}
+
 //
 // ------------------------------ HELPER FUNCTIONS ----------------------------
 //
 func (xp *XPaxos) getLeader() int {
-	return ((xp.view - 1) % (len(xp.replicas) - 1)) + 1
+	return xp.leaderOf(xp.view)
+}
+
+func (xp *XPaxos) leaderOf(view int) int {
+	return ((view - 1) % (len(xp.replicas) - 1)) + 1
 }
 
 func (xp *XPaxos) generateSynchronousGroup(seed int64) {
-	r := rand.New(rand.NewSource(seed))
+	xp.synchronousGroup = xp.groupOf(int(seed))
+
+	if xp.synchronousGroup[xp.id] != true {
+		xp.synchronousGroup = make(map[int]bool, 0)
+	}
+}
+
+// Synchronous group of a view (whether or not the XPaxos server is a member)
+func (xp *XPaxos) groupOf(view int) map[int]bool {
+	r := rand.New(rand.NewSource(int64(view)))
 	numAdded := 0
 
-	xp.synchronousGroup = make(map[int]bool, 0)
-	xp.synchronousGroup[xp.getLeader()] = true
+	group := make(map[int]bool, 0)
+	group[xp.leaderOf(view)] = true
 
 	for _, server := range r.Perm(len(xp.replicas)) {
-		if server != CLIENT && server != xp.getLeader() && numAdded < (len(xp.replicas)-1)/2 {
-			xp.synchronousGroup[server] = true
+		if server != CLIENT && server != xp.leaderOf(view) && numAdded < (len(xp.replicas)-1)/2 {
+			group[server] = true
 			numAdded++
 		}
 	}
-
-	if xp.synchronousGroup[xp.id] != true {
-		xp.synchronousGroup = make(map[int]bool, 0)
-	}
+	return group
 }
 
 func (xp *XPaxos) appendToPrepareLog(request ClientRequest, msg Message) PrepareLogEntry {
@@ -97,6 +283,7 @@ func (xp *XPaxos) appendToPrepareLog(request ClientRequest, msg Message) Prepare
 		Msg0:    msg}
 
 	xp.prepareLog = append(xp.prepareLog, prepareEntry)
+	xp.clientTimestamps[request.ClientId] = request.Timestamp
 	return prepareEntry
 }
 
@@ -118,6 +305,110 @@ func (xp *XPaxos) updatePrepareLog(seqNum int, request ClientRequest, msg Messag
 	xp.prepareLog[seqNum] = prepareEntry
 }
 
+// Report the commit log entry at seqNum to the monitor once it holds the commits of every other
+// member of the synchronous group
+func (xp *XPaxos) checkCommitted(seqNum int) {
+	if len(xp.commitLog[seqNum].Msg1) == len(xp.synchronousGroup)-1 {
+		xp.monitor.commit(xp.id, xp.view, seqNum, xp.commitLog[seqNum])
+	}
+}
+
+// Room for n outstanding calls (so that completing one never blocks)
+func makeBroadcast(n int) *broadcast {
+	b := &broadcast{}
+	b.done = make(chan *network.Call, n)
+	b.calls = make(map[*network.Call]int, 0)
+	return b
+}
+
+func (b *broadcast) add(server int, call *network.Call) {
+	b.calls[call] = server
+}
+
+// Apply the commit log entries up to executeSeqNum to the state machine
+func (xp *XPaxos) execute() {
+	for xp.applySeqNum < xp.executeSeqNum && xp.applySeqNum < len(xp.commitLog) {
+		request := xp.commitLog[xp.applySeqNum].Request
+		xp.monitor.execute(xp.id, xp.view, xp.applySeqNum, xp.commitLog[xp.applySeqNum])
+
+		var result interface{}
+		if op, ok := request.Operation.(ReadmitOp); ok {
+			xp.readmit(op, xp.commitLog[xp.applySeqNum].View)
+		} else if xp.stateMachine != nil {
+			result = xp.stateMachine.Apply(request.Operation)
+		}
+
+		xp.clientResults[request.ClientId] = clientResult{request.Timestamp, digest(request), xp.applySeqNum + 1, result}
+		xp.applySeqNum++
+	}
+}
+
+// Result and sequence number of a client request if it is the latest one applied for the client;
+// executed is false if the request hasn't been applied yet
+// => A faulty leader may have committed another request with the same timestamp, whose result
+//    isn't the request's
+func (xp *XPaxos) getClientResult(request ClientRequest) (result interface{}, seqNum int, executed bool) {
+	clientResult, ok := xp.clientResults[request.ClientId]
+	if ok == false || clientResult.Timestamp < request.Timestamp {
+		return nil, 0, false
+	}
+	if clientResult.Timestamp == request.Timestamp && clientResult.MsgDigest == digest(request) {
+		result, seqNum = clientResult.Result, clientResult.SeqNum
+	}
+	return result, seqNum, true
+}
+
+// Commit certificate of the latest request applied for a client if it has the request's timestamp:
+// either the request's own, or that of another request which superseded it (see client.go)
+func (xp *XPaxos) getClientCertificate(request ClientRequest) *Certificate {
+	clientResult, ok := xp.clientResults[request.ClientId]
+	if ok == false || clientResult.Timestamp != request.Timestamp {
+		return nil
+	}
+	return xp.certificate(clientResult.SeqNum - 1)
+}
+
+// Latest timestamp in the prepare log for a client (-1 if it has none)
+func (xp *XPaxos) getClientTimestamp(clientId int) int {
+	if timestamp, ok := xp.clientTimestamps[clientId]; ok {
+		return timestamp
+	}
+	return -1
+}
+
+func (xp *XPaxos) resetClientTimestamps() {
+	xp.clientTimestamps = make(map[int]int, 0)
+
+	for _, prepareEntry := range xp.prepareLog {
+		request := prepareEntry.Request
+		if request.Timestamp > xp.getClientTimestamp(request.ClientId) {
+			xp.clientTimestamps[request.ClientId] = request.Timestamp
+		}
+	}
+}
+
+// Check a client request before it is prepared: a faulty client can't use a timestamp more than
+// WINDOW ahead of its latest one (so it can't exhaust its timestamps or make later requests look
+// like duplicates), nor an operation that is oversized or has no digest
+// => Timestamps are tracked per client, so clients can't interfere with each other's requests
+//    through their own client IDs
+// => Client IDs aren't authenticated (requests aren't signed), so a faulty client can still send
+//    requests as another client: each one may move that client's timestamp up to WINDOW ahead,
+//    after which the client's own requests look like duplicates and are never committed (see
+//    TestFaultyClient4)
+func (xp *XPaxos) validRequest(request ClientRequest) bool {
+	if request.MsgType != REPLICATE || request.ClientId < 0 || request.Timestamp < 0 {
+		return false
+	}
+
+	if request.Timestamp > xp.getClientTimestamp(request.ClientId)+WINDOW {
+		return false
+	}
+
+	jsonBytes, err := json.Marshal(request.Operation) // i.e. NaN can't be digested
+	return err == nil && len(jsonBytes) <= MAXOP
+}
+
 func (xp *XPaxos) compareLogs(prepareLog []PrepareLogEntry, commitLog []CommitLogEntry) bool {
 	var commitEntryMsg0 Message
 	var check1 int
@@ -142,12 +433,47 @@ func (xp *XPaxos) compareLogs(prepareLog []PrepareLogEntry, commitLog []CommitLo
 	}
 }
 
+// View-change messages in xp.vcSet ordered by sender (then digest), so that commit logs are
+// merged deterministically
+func (xp *XPaxos) sortedVCSet() []ViewChangeMessage {
+	msgDigests := make([][32]byte, 0, len(xp.vcSet))
+	for msgDigest, _ := range xp.vcSet {
+		msgDigests = append(msgDigests, msgDigest)
+	}
+
+	sort.Slice(msgDigests, func(i int, j int) bool {
+		msg1 := xp.vcSet[msgDigests[i]]
+		msg2 := xp.vcSet[msgDigests[j]]
+		if msg1.SenderId != msg2.SenderId {
+			return msg1.SenderId < msg2.SenderId
+		}
+		return bytes.Compare(msgDigests[i][:], msgDigests[j][:]) < 0
+	})
+
+	msgs := make([]ViewChangeMessage, 0, len(msgDigests))
+	for _, msgDigest := range msgDigests {
+		msgs = append(msgs, xp.vcSet[msgDigest])
+	}
+	return msgs
+}
+
+// Every view-change message forwarded in a VC-final message for view must be signed by its sender
+func (xp *XPaxos) verifyVCSet(view int, vcSet map[[32]byte]ViewChangeMessage) bool {
+	for _, msg := range vcSet {
+		msgDigest := viewDigest(VIEWCHANGE, msg.View, msg.SenderId, commitLogDigest(msg.CommitLog))
+		if msg.View != view || msg.MsgDigest != msgDigest || xp.verify(msg.SenderId, msgDigest, msg.Signature) == false {
+			return false
+		}
+	}
+	return true
+}
+
 func (xp *XPaxos) setVCTimer() {
 	oldView := xp.view
 
 	xp.netFlag = true
 	xp.vcFlag = false
-	xp.vcTimer = time.NewTimer(3 * network.DELTA * time.Millisecond).C
+	xp.vcTimer = xp.clock.After(3 * network.DELTA * time.Millisecond)
 
 	go func(xp *XPaxos, oldView int) {
 		<-xp.vcTimer
@@ -161,9 +487,31 @@ func (xp *XPaxos) setVCTimer() {
 	}(xp, oldView)
 }
 
-func (xp *XPaxos) issueConfirmVC() bool {
+// Confirmation of a new view for the client, listing the timestamps of its committed requests
+func (xp *XPaxos) makeConfirmVC() ConfirmVCMessage {
+	timestamps := make([]int, 0)
+	for _, commitEntry := range xp.commitLog {
+		if commitEntry.Request.ClientId == CLIENT {
+			timestamps = append(timestamps, commitEntry.Request.Timestamp)
+		}
+	}
+
+	msgDigest := viewDigest(CONFIRMVC, xp.view, xp.id, timestampsDigest(timestamps))
+
+	return ConfirmVCMessage{
+		MsgType:    CONFIRMVC,
+		MsgDigest:  msgDigest,
+		Signature:  xp.sign(msgDigest),
+		View:       xp.view,
+		SenderId:   xp.id,
+		Timestamps: timestamps}
+}
+
+func (xp *XPaxos) issueConfirmVC(msg ConfirmVCMessage) bool {
 	dPrintf("ConfirmVC: from XPaxos server (%d) to client server (%d)\n", xp.id, CLIENT)
-	return xp.replicas[CLIENT].Call("Client.ConfirmVC", Message{}, &Reply{}, xp.id)
+	// The client may be unreachable, so don't wait forever
+	return xp.replicas[CLIENT].CallWithTimeout("Client.ConfirmVC", msg, &Reply{}, xp.id,
+		3*network.DELTA*time.Millisecond)
 }
 
 //
@@ -260,22 +608,101 @@ func compareCommitLogEntriesChecker(commitLog1 []CommitLogEntry, commitLog2 []Co
 	return true
 }
 
+// Fail if any XPaxos server's commit log holds a request for operation op (i.e. a forged one)
+func checkNotCommitted(cfg *config, op []byte) {
+	for i := 1; i < cfg.n; i++ {
+		for _, commitEntry := range cfg.xpServers[i].commitLog {
+			if operation, ok := commitEntry.Request.Operation.([]byte); ok && bytes.Equal(operation, op) {
+				cfg.t.Fatalf("Forged request in commit log of XPaxos server (%d)!", i)
+			}
+		}
+	}
+}
+
+// Only the faulty XPaxos server (none if faulty is CLIENT) may be reported for sending invalid
+// commit certificates
+func checkFaultReports(cfg *config, faulty int) {
+	for i := 1; i < cfg.n; i++ {
+		for _, report := range cfg.xpServers[i].FaultReports() {
+			if report.ReplicaId != faulty {
+				cfg.t.Fatalf("XPaxos server (%d) reported correct XPaxos server (%d) (%s)!", i, report.ReplicaId,
+					report.Reason)
+			}
+		}
+	}
+}
+
+// Some XPaxos server must hold a valid proof of misbehaviour against the faulty XPaxos server, and
+// none against any other
+func checkMisbehaviourProofs(cfg *config, faulty int) {
+	proven := false
+
+	for i := 1; i < cfg.n; i++ {
+		for _, proof := range cfg.xpServers[i].MisbehaviourProofs() {
+			if proof.ReplicaId != faulty || cfg.xpServers[i].verifyProof(proof) == false {
+				cfg.t.Fatalf("XPaxos server (%d) holds an invalid proof against XPaxos server (%d)!", i, proof.ReplicaId)
+			}
+			proven = true
+		}
+	}
+
+	if proven == false {
+		cfg.t.Fatalf("No proof of misbehaviour against XPaxos server (%d)!", faulty)
+	}
+}
+
+// Count a client's requests in the commit logs of the synchronous group of the current view,
+// failing if any of them committed two requests of the client with the same timestamp
+func countClientRequests(cfg *config, clientId int) int {
+	currentView := getCurrentView(cfg)
+	count := 0
+
+	for i := 1; i < cfg.n; i++ {
+		if cfg.xpServers[i].view != currentView || len(cfg.xpServers[i].synchronousGroup) == 0 {
+			continue
+		}
+
+		timestamp := -1
+		numRequests := 0
+		for _, commitEntry := range cfg.xpServers[i].commitLog {
+			if commitEntry.Request.ClientId == clientId {
+				if commitEntry.Request.Timestamp <= timestamp {
+					cfg.t.Fatalf("Duplicate request of client (%d) in commit log of XPaxos server (%d)!", clientId, i)
+				}
+				timestamp = commitEntry.Request.Timestamp
+				numRequests++
+			}
+		}
+
+		if numRequests > count {
+			count = numRequests
+		}
+	}
+	return count
+}
+
+// Fail if the client's history isn't linearizable with respect to model (i.e. KVModel)
+func checkLinearizable(cfg *config, model Model) {
+	if CheckLinearizable(model, cfg.history.Entries()) == false {
+		cfg.t.Fatal("Client history is not linearizable!")
+	}
+}
+
+// View held by a majority of XPaxos servers; an XPaxos server that suspected a view while it was
+// cut off may have moved on alone, until the others suspect that view too
 func getCurrentView(cfg *config) int {
-	numCurrent := 0
-	currentView := 0
+	numServers := make(map[int]int, 0)
 
 	for _, xpServer := range cfg.xpServers[1:] {
-		if xpServer.view > currentView {
-			numCurrent = 1
-			currentView = xpServer.view
-		} else if xpServer.view == currentView {
-			numCurrent++
-		}
+		numServers[xpServer.view]++
 	}
 
-	if numCurrent < (len(cfg.xpServers)+1)/2 {
-		cfg.t.Fatal("Invalid current view (no majority)!")
+	for view, numCurrent := range numServers {
+		if numCurrent >= (len(cfg.xpServers)+1)/2 {
+			return view
+		}
 	}
 
-	return currentView
+	cfg.t.Fatal("Invalid current view (no majority)!")
+	return 0
 }
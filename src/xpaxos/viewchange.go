@@ -2,7 +2,6 @@ package xpaxos
 
 import (
 	"bytes"
-	//"math/rand"
 	"network"
 	"time"
 )
@@ -10,35 +9,46 @@ import (
 //
 // -------------------------------- SUSPECT RPC -------------------------------
 //
-func (xp *XPaxos) sendSuspect(server int, msg SuspectMessage, reply *Reply) bool {
-	//if xp.byzantine == true {
-	//	for i := len(msg.Signature) - 1; i > 0; i-- {
-	//		j := rand.Intn(i + 1)
-	//		msg.Signature[i], msg.Signature[j] = msg.Signature[j], msg.Signature[i]
-	//	}
-	//}
-
+func (xp *XPaxos) sendSuspect(server int, msg SuspectMessage, done chan *network.Call) *network.Call {
 	dPrintf("Suspect: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
-	return xp.replicas[server].Call("XPaxos.Suspect", msg, reply, xp.id)
+	return xp.replicas[server].Go("XPaxos.Suspect", msg, &Reply{}, xp.id, done)
 }
 
-func (xp *XPaxos) issueSuspectHelper(server int, msg SuspectMessage) {
-	reply := &Reply{}
+// Must hold xp.mu
+func (xp *XPaxos) broadcastSuspect(msg SuspectMessage) {
+	b := makeBroadcast(len(xp.replicas) - 1)
 
-	if ok := xp.sendSuspect(server, msg, reply); ok {
-		xp.mu.Lock()
-		if xp.view != msg.View {
-			xp.mu.Unlock()
-			return
+	for server, _ := range xp.replicas {
+		if server != CLIENT {
+			b.add(server, xp.sendSuspect(server, msg, b.done))
 		}
+	}
+	go xp.handleSuspectReplies(b, msg)
+}
+
+// Failed calls are retried with one more broadcast, not one per failed call, so that suspects
+// don't multiply while XPaxos servers are unreachable
+func (xp *XPaxos) handleSuspectReplies(b *broadcast, msg SuspectMessage) {
+	retry := false
 
-		verification := xp.verify(server, reply.MsgDigest, reply.Signature)
+	for i := 0; i < len(b.calls); i++ {
+		call := <-b.done
+		server, reply := b.calls[call], call.Reply.(*Reply)
 
-		if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) != 0 || verification == false {
+		if call.Ok == false {
+			retry = true
+			continue
+		}
+
+		xp.mu.Lock()
+		if xp.view == msg.View && (bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) != 0 ||
+			xp.verifyReply(server, reply) == false) {
 			go xp.issueSuspect(xp.view)
 		}
 		xp.mu.Unlock()
-	} else {
+	}
+
+	if retry == true {
 		go xp.issueSuspect(msg.View)
 	}
 }
@@ -51,7 +61,7 @@ func (xp *XPaxos) issueSuspect(view int) {
 		return
 	}
 
-	msgDigest := digest(xp.view)
+	msgDigest := viewDigest(SUSPECT, xp.view, xp.id, [32]byte{})
 	signature := xp.sign(msgDigest)
 
 	msg := SuspectMessage{
@@ -59,46 +69,44 @@ func (xp *XPaxos) issueSuspect(view int) {
 		MsgDigest: msgDigest,
 		Signature: signature,
 		View:      xp.view,
-		SenderId:  xp.id}
+		SenderId:  xp.id,
+		Proofs:    xp.copyProofs()}
 
-	for server, _ := range xp.replicas {
-		if server != CLIENT {
-			go xp.issueSuspectHelper(server, msg)
-		}
-	}
+	xp.broadcastSuspect(msg)
 }
 
 func (xp *XPaxos) forwardSuspect(msg SuspectMessage) {
 	xp.mu.Lock()
 	defer xp.mu.Unlock()
 
-	if xp.view != msg.View+1 {
+	if xp.view != xp.nextView(msg.View+1) {
 		return
 	}
 
-	for server, _ := range xp.replicas {
-		if server != CLIENT {
-			go xp.issueSuspectHelper(server, msg)
-		}
-	}
+	msg.Proofs = xp.copyProofs() // Proofs aren't signed, so relay the whole record
+	xp.broadcastSuspect(msg)
 }
 
 func (xp *XPaxos) Suspect(msg SuspectMessage, reply *Reply) {
+	defer xp.signReply(reply)
+
 	xp.mu.Lock()
 	defer xp.mu.Unlock()
 
-	msgDigest := digest(msg.View)
-	signature := xp.sign(msgDigest)
+	msgDigest := viewDigest(SUSPECT, msg.View, msg.SenderId, [32]byte{})
 	reply.MsgDigest = msgDigest
-	reply.Signature = signature
 
 	_, ok := xp.suspectSet[digest(msg)]
 
 	if bytes.Compare(msg.MsgDigest[:], msgDigest[:]) == 0 && xp.verify(msg.SenderId, msgDigest, msg.Signature) == true {
-		if xp.view <= msg.View && ok == false {
+		xp.adoptProofs(msg.Proofs) // Agree on convictions before skipping views
+
+		// Skip views whose synchronous group has a convicted replica; an XPaxos server that already
+		// left msg.View moves again only if the proofs skip past its view
+		if view := xp.nextView(msg.View + 1); xp.view < view && ok == false {
 			xp.suspectSet[digest(msg)] = msg
 
-			xp.view = msg.View + 1
+			xp.view = view
 			go xp.forwardSuspect(msg)
 
 			xp.generateSynchronousGroup(int64(xp.view))
@@ -110,7 +118,7 @@ func (xp *XPaxos) Suspect(msg SuspectMessage, reply *Reply) {
 
 			if len(xp.synchronousGroup) > 0 {
 				xp.netFlag = false
-				xp.netTimer = time.NewTimer(3 * network.DELTA * time.Millisecond).C
+				xp.netTimer = xp.clock.After(3 * network.DELTA * time.Millisecond)
 			}
 		}
 	} else {
@@ -121,16 +129,9 @@ func (xp *XPaxos) Suspect(msg SuspectMessage, reply *Reply) {
 //
 // ------------------------------ VIEW-CHANGE RPC -----------------------------
 //
-func (xp *XPaxos) sendViewChange(server int, msg ViewChangeMessage, reply *Reply) bool {
-	//if xp.byzantine == true {
-	//	for i := len(msg.Signature) - 1; i > 0; i-- {
-	//		j := rand.Intn(i + 1)
-	//		msg.Signature[i], msg.Signature[j] = msg.Signature[j], msg.Signature[i]
-	//	}
-	//}
-
+func (xp *XPaxos) sendViewChange(server int, msg ViewChangeMessage, done chan *network.Call) *network.Call {
 	dPrintf("ViewChange: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
-	return xp.replicas[server].Call("XPaxos.ViewChange", msg, reply, xp.id)
+	return xp.replicas[server].Go("XPaxos.ViewChange", msg, &Reply{}, xp.id, done)
 }
 
 func (xp *XPaxos) issueViewChange(view int) {
@@ -141,7 +142,7 @@ func (xp *XPaxos) issueViewChange(view int) {
 		return
 	}
 
-	msgDigest := digest(xp.view)
+	msgDigest := viewDigest(VIEWCHANGE, xp.view, xp.id, commitLogDigest(xp.commitLog))
 	signature := xp.sign(msgDigest)
 
 	msg := ViewChangeMessage{
@@ -150,42 +151,54 @@ func (xp *XPaxos) issueViewChange(view int) {
 		Signature: signature,
 		View:      xp.view,
 		SenderId:  xp.id,
-		CommitLog: xp.commitLog}
+		CommitLog: xp.commitLog,
+		Proofs:    xp.copyProofs()}
 
-	for server, _ := range xp.synchronousGroup {
-		go func(xp *XPaxos, server int, msg ViewChangeMessage) {
-			reply := &Reply{}
+	// XPaxos servers outside the new synchronous group send their commit log too, otherwise
+	// requests they committed could be lost (only members suspect the view if it isn't delivered)
+	member := len(xp.synchronousGroup) > 0
 
-			if ok := xp.sendViewChange(server, msg, reply); ok {
-				xp.mu.Lock()
-				if xp.view != msg.View {
-					xp.mu.Unlock()
-					return
-				}
+	group := xp.groupOf(xp.view)
+	b := makeBroadcast(len(group))
+
+	for server, _ := range group {
+		b.add(server, xp.sendViewChange(server, msg, b.done))
+	}
+	go xp.handleViewChangeReplies(b, msg, member)
+}
 
-				verification := xp.verify(server, reply.MsgDigest, reply.Signature)
+func (xp *XPaxos) handleViewChangeReplies(b *broadcast, msg ViewChangeMessage, member bool) {
+	for i := 0; i < len(b.calls); i++ {
+		call := <-b.done
+		server, reply := b.calls[call], call.Reply.(*Reply)
 
-				if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) != 0 || verification == false {
-					go xp.issueSuspect(xp.view)
-				}
-				xp.mu.Unlock()
-			} else {
+		if call.Ok == false {
+			if member == true {
 				go xp.issueSuspect(msg.View)
 			}
-		}(xp, server, msg)
+			continue
+		}
+
+		xp.mu.Lock()
+		if xp.view == msg.View && (bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) != 0 ||
+			xp.verifyReply(server, reply) == false) {
+			go xp.issueSuspect(xp.view)
+		}
+		xp.mu.Unlock()
 	}
 }
 
 func (xp *XPaxos) ViewChange(msg ViewChangeMessage, reply *Reply) {
+	defer xp.signReply(reply)
+
 	xp.mu.Lock()
-	msgDigest := digest(msg.View)
-	signature := xp.sign(msgDigest)
+	msgDigest := viewDigest(VIEWCHANGE, msg.View, msg.SenderId, commitLogDigest(msg.CommitLog))
 	reply.MsgDigest = msgDigest
-	reply.Signature = signature
 
 	if xp.view == msg.View {
 		if bytes.Compare(msg.MsgDigest[:], msgDigest[:]) == 0 && xp.verify(msg.SenderId, msgDigest, msg.Signature) == true {
 			xp.vcSet[digest(msg)] = msg
+			xp.adoptProofs(msg.Proofs)
 
 			if len(xp.vcSet) == len(xp.replicas)-1 {
 				xp.setVCTimer()
@@ -220,16 +233,9 @@ func (xp *XPaxos) ViewChange(msg ViewChangeMessage, reply *Reply) {
 //
 // ------------------------------- VC-FINAL RPC -------------------------------
 //
-func (xp *XPaxos) sendVCFinal(server int, msg VCFinalMessage, reply *Reply) bool {
-	//if xp.byzantine == true {
-	//	for i := len(msg.Signature) - 1; i > 0; i-- {
-	//		j := rand.Intn(i + 1)
-	//		msg.Signature[i], msg.Signature[j] = msg.Signature[j], msg.Signature[i]
-	//	}
-	//}
-
+func (xp *XPaxos) sendVCFinal(server int, msg VCFinalMessage, done chan *network.Call) *network.Call {
 	dPrintf("VCFinal: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
-	return xp.replicas[server].Call("XPaxos.VCFinal", msg, reply, xp.id)
+	return xp.replicas[server].Go("XPaxos.VCFinal", msg, &Reply{}, xp.id, done)
 }
 
 func (xp *XPaxos) issueVCFinal(view int) {
@@ -246,7 +252,7 @@ func (xp *XPaxos) issueVCFinal(view int) {
 		vcSetCopy[msgDigest] = msg
 	}
 
-	msgDigest := digest(xp.view)
+	msgDigest := viewDigest(VCFINAL, xp.view, xp.id, vcSetDigest(vcSetCopy))
 	signature := xp.sign(msgDigest)
 
 	msg := VCFinalMessage{
@@ -257,43 +263,47 @@ func (xp *XPaxos) issueVCFinal(view int) {
 		SenderId:  xp.id,
 		VCSet:     vcSetCopy}
 
+	b := makeBroadcast(len(xp.synchronousGroup))
+
 	for server, _ := range xp.synchronousGroup {
-		go func(xp *XPaxos, server int, msg VCFinalMessage) {
-			reply := &Reply{}
+		b.add(server, xp.sendVCFinal(server, msg, b.done))
+	}
+	go xp.handleVCFinalReplies(b, msg)
+}
 
-			if ok := xp.sendVCFinal(server, msg, reply); ok {
-				xp.mu.Lock()
-				if xp.view != msg.View {
-					xp.mu.Unlock()
-					return
-				}
+func (xp *XPaxos) handleVCFinalReplies(b *broadcast, msg VCFinalMessage) {
+	for i := 0; i < len(b.calls); i++ {
+		call := <-b.done
+		server, reply := b.calls[call], call.Reply.(*Reply)
 
-				verification := xp.verify(server, reply.MsgDigest, reply.Signature)
+		if call.Ok == false {
+			go xp.issueSuspect(msg.View)
+			continue
+		}
 
-				if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) != 0 || verification == false {
-					go xp.issueSuspect(xp.view)
-				}
-				xp.mu.Unlock()
-			} else {
-				go xp.issueSuspect(msg.View)
-			}
-		}(xp, server, msg)
+		xp.mu.Lock()
+		if xp.view == msg.View && (bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) != 0 ||
+			xp.verifyReply(server, reply) == false) {
+			go xp.issueSuspect(xp.view)
+		}
+		xp.mu.Unlock()
 	}
 }
 
 func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
+	defer xp.signReply(reply)
+
 	xp.mu.Lock()
 	if xp.view != msg.View {
 		xp.mu.Unlock()
 		return
 	}
 
-	msgDigest := digest(msg.View)
-	signature := xp.sign(msgDigest)
+	msgDigest := viewDigest(VCFINAL, msg.View, msg.SenderId, vcSetDigest(msg.VCSet))
 	reply.MsgDigest = msgDigest
-	reply.Signature = signature
 
-	if bytes.Compare(msg.MsgDigest[:], msgDigest[:]) == 0 && xp.verify(msg.SenderId, msgDigest, msg.Signature) == true {
+	if bytes.Compare(msg.MsgDigest[:], msgDigest[:]) == 0 && xp.verify(msg.SenderId, msgDigest, msg.Signature) == true &&
+		xp.verifyVCSet(msg.View, msg.VCSet) == true {
 		for senderId, vcSet := range xp.receivedVCFinal { // This could/*should* be made more efficient
 			for _, msg := range vcSet {
 				if xp.view != msg.View {
@@ -310,17 +320,8 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 					xp.vcSet[digest(msg)] = msg
 				}
 
-				for _, msg := range xp.vcSet {
-					for seqNum, _ := range msg.CommitLog {
-						if len(xp.commitLog) <= seqNum {
-							xp.commitLog = append(xp.commitLog, msg.CommitLog[seqNum])
-						} else {
-							if xp.commitLog[seqNum].View < msg.CommitLog[seqNum].View {
-								xp.commitLog[seqNum] = msg.CommitLog[seqNum]
-							}
-						}
-					}
-				}
+				xp.mergeCommitLogs()
+				xp.mergeProofs()
 
 				if xp.id == xp.getLeader() {
 					var request ClientRequest
@@ -329,20 +330,23 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 					var msgDigest [32]byte
 					var signature []byte
 
+					if len(xp.prepareLog) > len(xp.commitLog) { // Requests prepared but not committed
+						xp.prepareLog = xp.prepareLog[:len(xp.commitLog)]
+					}
+
 					for seqNum, _ := range xp.commitLog {
 						request = xp.commitLog[seqNum].Request
 						msg0 = xp.commitLog[seqNum].Msg0
 						msgDigest = digest(request)
-						signature = xp.sign(msgDigest)
 
 						newMsg0 = Message{
 							MsgType:         PREPARE,
 							MsgDigest:       msgDigest,
-							Signature:       signature,
 							PrepareSeqNum:   seqNum + 1,
 							View:            xp.view,
 							ClientTimestamp: msg0.ClientTimestamp,
-							SenderId:        msg0.SenderId}
+							SenderId:        xp.id}
+						newMsg0.Signature = xp.sign(prepareDigest(newMsg0)) // The new leader signs the re-issued prepare
 
 						if seqNum < len(xp.prepareLog) {
 							xp.updatePrepareLog(seqNum, request, newMsg0)
@@ -350,8 +354,9 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 							xp.appendToPrepareLog(request, newMsg0)
 						}
 					}
+					xp.resetClientTimestamps()
 
-					msgDigest = digest(xp.view)
+					msgDigest = viewDigest(NEWVIEW, xp.view, xp.id, prepareLogDigest(xp.prepareLog))
 					signature = xp.sign(msgDigest)
 
 					msg := NewViewMessage{
@@ -363,23 +368,27 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 						SenderId:   xp.id}
 
 					numReplies := len(xp.synchronousGroup) - 1
-					replyCh := make(chan bool, numReplies)
+					b := makeBroadcast(numReplies)
 
 					for server, _ := range xp.synchronousGroup {
 						if server != xp.id {
-							go xp.issueNewView(server, msg, replyCh)
+							b.add(server, xp.sendNewView(server, msg, b.done))
 						}
 					}
 					xp.mu.Unlock()
 
-					timer := time.NewTimer(3 * network.DELTA * time.Millisecond).C
+					timer := xp.clock.After(3 * network.DELTA * time.Millisecond)
 
-					for i := 0; i < numReplies; i++ {
+					for received := 0; received < numReplies; {
 						select {
 						case <-timer:
 							dPrintf("Timeout: XPaxos.VCFinal: XPaxos server (%d)\n", xp.id)
+							go xp.issueSuspect(msg.View)
 							return
-						case <-replyCh:
+						case call := <-b.done:
+							if xp.handleNewViewReply(b.calls[call], call, msg) == true {
+								received++
+							}
 						}
 					}
 
@@ -389,7 +398,9 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 						return
 					}
 
-					go xp.issueNewView(xp.id, msg, replyCh)
+					self := makeBroadcast(1) // The leader installs the new view last
+					self.add(xp.id, xp.sendNewView(xp.id, msg, self.done))
+					go xp.handleNewViewReplies(self, msg)
 				}
 			}
 		}
@@ -399,47 +410,105 @@ func (xp *XPaxos) VCFinal(msg VCFinalMessage, reply *Reply) {
 	xp.mu.Unlock()
 }
 
+// Only adopt committed requests, that is entries with a commit certificate (see certificate.go),
+// starting from those of the XPaxos server itself; requests after them that may have been
+// committed are left to the new view to prepare first
+func (xp *XPaxos) mergeCommitLogs() {
+	certified, prepared := xp.splitCommitLog(xp.commitLog, xp.view, xp.id)
+	xp.commitLog = certified
+	preparedEntries := make(map[int]CommitLogEntry, 0)
+	addPrepared(preparedEntries, len(certified), prepared)
+
+	for _, msg := range xp.sortedVCSet() { // Same merge order on every XPaxos server
+		certified, prepared = xp.splitCommitLog(msg.CommitLog, msg.View, msg.SenderId)
+		addPrepared(preparedEntries, len(certified), prepared)
+
+		for seqNum, commitEntry := range certified {
+			if len(xp.commitLog) <= seqNum {
+				xp.commitLog = append(xp.commitLog, commitEntry)
+			} else if newerEntry(commitEntry, xp.commitLog[seqNum]) == true {
+				xp.commitLog[seqNum] = commitEntry
+			}
+		}
+	}
+
+	// Requests after the merged commit log that may have been committed, in sequence number order
+	xp.pending = make([]ClientRequest, 0)
+	for seqNum := len(xp.commitLog); ; seqNum++ {
+		commitEntry, ok := preparedEntries[seqNum]
+		if ok == false {
+			break
+		}
+		xp.pending = append(xp.pending, commitEntry.Request)
+	}
+}
+
+// Keep the newest entry prepared at each sequence number (entries start at sequence number first)
+func addPrepared(preparedEntries map[int]CommitLogEntry, first int, entries []CommitLogEntry) {
+	for i, commitEntry := range entries {
+		if oldEntry, ok := preparedEntries[first+i]; ok == false || newerEntry(commitEntry, oldEntry) == true {
+			preparedEntries[first+i] = commitEntry
+		}
+	}
+}
+
+// Entries of the same view only differ if their leader equivocated, so break ties by digest to
+// agree on one of them
+func newerEntry(commitEntry CommitLogEntry, oldEntry CommitLogEntry) bool {
+	return oldEntry.View < commitEntry.View || (oldEntry.View == commitEntry.View &&
+		bytes.Compare(commitEntry.Msg0.MsgDigest[:], oldEntry.Msg0.MsgDigest[:]) < 0)
+}
+
+// Convictions are agreed on by the new synchronous group (see misbehaviour.go)
+func (xp *XPaxos) mergeProofs() {
+	for _, msg := range xp.sortedVCSet() {
+		xp.adoptProofs(msg.Proofs)
+	}
+}
+
 //
 // -------------------------------- NEW-VIEW RPC ------------------------------
 //
-func (xp *XPaxos) sendNewView(server int, msg NewViewMessage, reply *Reply) bool {
-	//if xp.byzantine == true {
-	//	for i := len(msg.Signature) - 1; i > 0; i-- {
-	//		j := rand.Intn(i + 1)
-	//		msg.Signature[i], msg.Signature[j] = msg.Signature[j], msg.Signature[i]
-	//	}
-	//}
-
+func (xp *XPaxos) sendNewView(server int, msg NewViewMessage, done chan *network.Call) *network.Call {
 	dPrintf("NewView: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
-	return xp.replicas[server].Call("XPaxos.NewView", msg, reply, xp.id)
+	return xp.replicas[server].Go("XPaxos.NewView", msg, &Reply{}, xp.id, done)
 }
 
-func (xp *XPaxos) issueNewView(server int, msg NewViewMessage, replyCh chan bool) {
-	reply := &Reply{}
+// Reports whether server installed the new view
+func (xp *XPaxos) handleNewViewReply(server int, call *network.Call, msg NewViewMessage) bool {
+	reply := call.Reply.(*Reply)
 
-	if ok := xp.sendNewView(server, msg, reply); ok {
-		xp.mu.Lock()
-		if xp.view != msg.View {
-			xp.mu.Unlock()
-			return
-		}
+	if call.Ok == false {
+		go xp.issueSuspect(msg.View)
+		return false
+	}
 
-		verification := xp.verify(server, reply.MsgDigest, reply.Signature)
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
 
-		if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) == 0 && verification == true {
-			if reply.Success == true {
-				replyCh <- reply.Success
-			}
-		} else {
-			go xp.issueSuspect(xp.view)
-		}
-		xp.mu.Unlock()
-	} else {
-		go xp.issueSuspect(msg.View)
+	if xp.view != msg.View {
+		return false
+	}
+
+	verification := xp.verifyReply(server, reply)
+
+	if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) == 0 && verification == true {
+		return reply.Success
+	}
+	go xp.issueSuspect(xp.view)
+	return false
+}
+
+func (xp *XPaxos) handleNewViewReplies(b *broadcast, msg NewViewMessage) {
+	for i := 0; i < len(b.calls); i++ {
+		call := <-b.done
+		xp.handleNewViewReply(b.calls[call], call, msg)
 	}
 }
 
 func (xp *XPaxos) NewView(msg NewViewMessage, reply *Reply) {
+	defer xp.signReply(reply)
+
 	xp.mu.Lock()
 	defer xp.mu.Unlock()
 
@@ -447,18 +516,29 @@ func (xp *XPaxos) NewView(msg NewViewMessage, reply *Reply) {
 		return
 	}
 
-	msgDigest := digest(msg.View)
-	signature := xp.sign(msgDigest)
+	msgDigest := viewDigest(NEWVIEW, msg.View, msg.SenderId, prepareLogDigest(msg.PrepareLog))
 	reply.MsgDigest = msgDigest
-	reply.Signature = signature
 
 	xp.vcFlag = true
 
-	if bytes.Compare(msg.MsgDigest[:], msgDigest[:]) == 0 && xp.verify(msg.SenderId, msgDigest, msg.Signature) == true {
+	if bytes.Compare(msg.MsgDigest[:], msgDigest[:]) == 0 && xp.verify(msg.SenderId, msgDigest, msg.Signature) == true &&
+		msg.SenderId == xp.getLeader() {
+		// A member that missed VC-Final messages still merges the view-change messages it holds, so
+		// that it doesn't keep stale entries for requests that were prepared again (i.e. resent by
+		// the client) in a later view; once the view is installed, its own entries are newer than
+		// any of those
+		if xp.vcInProgress == true && len(xp.receivedVCFinal) < len(xp.synchronousGroup) {
+			xp.mergeCommitLogs()
+			xp.mergeProofs()
+		}
+
 		if xp.compareLogs(msg.PrepareLog, xp.commitLog) {
 			xp.prepareLog = msg.PrepareLog
 			xp.prepareSeqNum = len(xp.prepareLog)
+			xp.resetClientTimestamps()
 			xp.executeSeqNum = len(xp.commitLog)
+			xp.monitor.newView(xp.id, xp.view, xp.commitLog, xp.pending)
+			xp.execute()
 
 			xp.suspectSet = make(map[[32]byte]SuspectMessage, 0)
 			xp.vcSet = make(map[[32]byte]ViewChangeMessage, 0)
@@ -466,7 +546,7 @@ func (xp *XPaxos) NewView(msg NewViewMessage, reply *Reply) {
 			xp.vcInProgress = false
 
 			if xp.id == xp.getLeader() {
-				go xp.issueConfirmVC()
+				go xp.preparePending(xp.view)
 			}
 
 			reply.Success = true
@@ -477,3 +557,44 @@ func (xp *XPaxos) NewView(msg NewViewMessage, reply *Reply) {
 		go xp.issueSuspect(xp.view)
 	}
 }
+
+// The leader of a new view prepares the requests of xp.pending before any other request, then
+// confirms the view to the client, so that the confirmation lists them
+// => A pending request that is still first in xp.pending after Replicate() returned wasn't
+//    prepared: the leader refused it (i.e. an invalid or duplicate request). Whoever executed a
+//    request prepared it after every request before it, which passed the same checks, so neither
+//    it nor any pending request after it was executed; they are all dropped, rather than prepared
+//    at sequence numbers other than their own
+func (xp *XPaxos) preparePending(view int) {
+	for {
+		xp.mu.Lock()
+		if xp.view != view {
+			xp.mu.Unlock()
+			return
+		}
+		if xp.vcInProgress == true { // Wait for the view change to install a view
+			xp.mu.Unlock()
+			xp.clock.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if len(xp.pending) == 0 {
+			msg := xp.makeConfirmVC()
+			xp.mu.Unlock()
+
+			xp.issueConfirmVC(msg)
+			return
+		}
+		request := xp.pending[0]
+		xp.mu.Unlock()
+
+		xp.Replicate(request, &Reply{})
+
+		xp.mu.Lock()
+		if xp.view == view && xp.vcInProgress == false && len(xp.pending) > 0 && digest(xp.pending[0]) == digest(request) {
+			iPrintf("Replicate: XPaxos server (%d) refused pending request from client (%d), dropping it and "+
+				"the (%d) pending requests after it\n", xp.id, request.ClientId, len(xp.pending)-1)
+			xp.pending = make([]ClientRequest, 0)
+		}
+		xp.mu.Unlock()
+	}
+}
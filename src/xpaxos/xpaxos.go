@@ -16,11 +16,17 @@ package xpaxos
 //
 // xp := Make(replicas, id, privateKey, publicKeys) - Creates an XPaxos server
 // => Option to perform cleanup with xp.Kill()
+// => Option to run timers on a virtual clock with xp.SetClock(net.Clock())
+// => Option to execute requests with xp.SetStateMachine(MakeKVStore()) (see kv.go)
+// => Option to check safety online with xp.SetMonitor(MakeMonitor(fail)) (see monitor.go)
+// => XPaxos servers caught sending invalid commit certificates are listed by xp.FaultReports()
+//    (see certificate.go)
+// => Leaders caught sending conflicting prepares are listed, with proof, by
+//    xp.MisbehaviourProofs() and excluded from later synchronous groups (see misbehaviour.go)
 
 import (
 	"bytes"
 	"crypto/rsa"
-	"math/rand"
 	"network"
 	"time"
 )
@@ -30,31 +36,55 @@ import (
 //
 func (xp *XPaxos) Replicate(request ClientRequest, reply *Reply) {
 	// By default reply.IsLeader = false and reply.Success = false
+	defer xp.signReply(reply)
+
 	xp.mu.Lock()
 	msgDigest := digest(request)
-	signature := xp.sign(msgDigest)
 	reply.MsgDigest = msgDigest
-	reply.Signature = signature
 
 	if xp.id == xp.getLeader() { // If XPaxos server is the leader
 		reply.IsLeader = true
 
-		if len(xp.prepareLog) > 0 && request.Timestamp <= xp.prepareLog[len(xp.prepareLog)-1].Msg0.ClientTimestamp {
+		if xp.vcInProgress == true { // Requests prepared before the new view is installed could be lost
+			xp.mu.Unlock()
+			return
+		}
+
+		// Requests of earlier views keep their sequence numbers (see certificate.go), so the next
+		// pending request is only taken off xp.pending once it is prepared
+		pending := len(xp.pending) > 0
+		if pending == true && msgDigest != digest(xp.pending[0]) {
+			xp.mu.Unlock()
+			return
+		}
+
+		if xp.validRequest(request) == false { // Faulty client
+			dPrintf("Replicate: invalid request from client (%d)\n", request.ClientId)
+			xp.mu.Unlock()
+			return
+		}
+
+		if request.Timestamp <= xp.getClientTimestamp(request.ClientId) {
+			// Only confirm a duplicate request once it has been executed, so that its result is known
+			reply.Result, reply.SeqNum, reply.Success = xp.getClientResult(request)
+			reply.Certificate = xp.getClientCertificate(request)
 			xp.mu.Unlock()
-			reply.Success = true
 			return
 		}
 
 		xp.prepareSeqNum++
+		if pending == true {
+			xp.pending = xp.pending[1:]
+		}
 
 		msg := Message{ // Leader's prepare message
 			MsgType:         PREPARE,
 			MsgDigest:       msgDigest,
-			Signature:       signature,
 			PrepareSeqNum:   xp.prepareSeqNum,
 			View:            xp.view,
 			ClientTimestamp: request.Timestamp,
 			SenderId:        xp.id}
+		msg.Signature = xp.sign(prepareDigest(msg))
 
 		prepareEntry := xp.appendToPrepareLog(request, msg)
 
@@ -62,36 +92,65 @@ func (xp *XPaxos) Replicate(request ClientRequest, reply *Reply) {
 		xp.appendToCommitLog(request, msg, msgMap)
 
 		numReplies := len(xp.synchronousGroup) - 1
-		replyCh := make(chan bool, numReplies)
+		b := makeBroadcast(numReplies)
 
 		for server, _ := range xp.synchronousGroup {
 			if server != xp.id {
-				go xp.issuePrepare(server, prepareEntry, replyCh)
+				b.add(server, xp.sendPrepare(server, prepareEntry, b.done))
 			}
 		}
 
 		xp.mu.Unlock()
 
-		timer := time.NewTimer(3 * network.DELTA * time.Millisecond).C
+		timer := xp.clock.After(3 * network.DELTA * time.Millisecond)
 
-		for i := 0; i < numReplies; i++ {
+		for received := 0; received < numReplies; {
 			select {
 			case <-timer:
 				dPrintf("Timeout: XPaxos.Replicate: XPaxos server (%d)\n", xp.id)
+				go xp.issueSuspect(msg.View) // A member of the synchronous group is slow or faulty
 				return
-			case <-replyCh:
+			case call := <-b.done:
+				if xp.handlePrepareReply(b.calls[call], call, prepareEntry) == true {
+					received++
+				}
 			}
 		}
 
+		timer = xp.clock.After(3 * network.DELTA * time.Millisecond)
+		seqNum := msg.PrepareSeqNum - 1
+
+		// Busy wait until the leader receives commit messages from entire synchronous group, so
+		// that its commit log entry is a complete commit certificate (see certificate.go)
 		xp.mu.Lock()
+		for xp.view == msg.View && len(xp.commitLog[seqNum].Msg1) != len(xp.synchronousGroup)-1 {
+			xp.mu.Unlock()
+			select {
+			case <-timer:
+				dPrintf("Timeout: XPaxos.Replicate: XPaxos server (%d)\n", xp.id)
+				go xp.issueSuspect(msg.View)
+				return
+			default:
+				xp.clock.Sleep(10 * time.Millisecond)
+			}
+			xp.mu.Lock()
+		}
+
 		if xp.view != msg.View {
 			xp.mu.Unlock()
 			return
 		}
 
 		xp.executeSeqNum++
-		reply.Success = true
+		xp.execute()
+		reply.Result, reply.SeqNum, reply.Success = xp.getClientResult(request)
+		reply.Certificate = xp.getClientCertificate(request)
 	} else {
+		// Members of the synchronous group confirm requests they executed, so that the client can
+		// collect a matching reply from every member
+		if len(xp.synchronousGroup) > 0 && request.Timestamp <= xp.getClientTimestamp(request.ClientId) {
+			reply.Result, reply.SeqNum, reply.Success = xp.getClientResult(request)
+		}
 		go xp.issuePing(xp.getLeader(), xp.view)
 	}
 	xp.mu.Unlock()
@@ -100,62 +159,67 @@ func (xp *XPaxos) Replicate(request ClientRequest, reply *Reply) {
 //
 // -------------------------------- PREPARE RPC -------------------------------
 //
-func (xp *XPaxos) sendPrepare(server int, prepareEntry PrepareLogEntry, reply *Reply) bool {
-	if xp.byzantine == true {
-		for i := len(prepareEntry.Msg0.Signature) - 1; i > 0; i-- {
-			j := rand.Intn(i + 1)
-			prepareEntry.Msg0.Signature[i], prepareEntry.Msg0.Signature[j] = prepareEntry.Msg0.Signature[j], prepareEntry.Msg0.Signature[i]
-		}
-	}
-
+func (xp *XPaxos) sendPrepare(server int, prepareEntry PrepareLogEntry, done chan *network.Call) *network.Call {
 	dPrintf("Prepare: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
-	return xp.replicas[server].Call("XPaxos.Prepare", prepareEntry, reply, xp.id)
+	return xp.replicas[server].Go("XPaxos.Prepare", prepareEntry, &Reply{}, xp.id, done)
 }
 
-func (xp *XPaxos) issuePrepare(server int, prepareEntry PrepareLogEntry, replyCh chan bool) {
-	reply := &Reply{}
+// Reports whether server accepted the prepare
+func (xp *XPaxos) handlePrepareReply(server int, call *network.Call, prepareEntry PrepareLogEntry) bool {
+	reply := call.Reply.(*Reply)
 
-	if ok := xp.sendPrepare(server, prepareEntry, reply); ok {
-		xp.mu.Lock()
-		if xp.view != prepareEntry.Msg0.View {
-			xp.mu.Unlock()
-			return
-		}
+	if call.Ok == false { // RPC times out after time frame delta (see network)
+		go xp.issueSuspect(prepareEntry.Msg0.View)
+		return false
+	}
 
-		verification := xp.verify(server, reply.MsgDigest, reply.Signature)
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
 
-		if bytes.Compare(prepareEntry.Msg0.MsgDigest[:], reply.MsgDigest[:]) == 0 && verification == true {
-			if reply.Success == true {
-				replyCh <- reply.Success
-			} else if reply.Suspicious == true {
-				xp.mu.Unlock()
-				return
-			}
-		} else { // Verification of crypto signature in reply fails
-			go xp.issueSuspect(xp.view)
-		}
-		xp.mu.Unlock()
-	} else { // RPC times out after time frame delta (see network)
-		go xp.issueSuspect(prepareEntry.Msg0.View)
+	if xp.view != prepareEntry.Msg0.View {
+		return false
+	}
+
+	verification := xp.verifyReply(server, reply)
+
+	if bytes.Compare(prepareEntry.Msg0.MsgDigest[:], reply.MsgDigest[:]) == 0 &&
+		reply.SeqNum == prepareEntry.Msg0.PrepareSeqNum && verification == true {
+		return reply.Success
 	}
+	go xp.issueSuspect(xp.view) // Verification of crypto signature in reply fails
+	return false
 }
 
 func (xp *XPaxos) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 	// By default reply.Success = false and reply.Suspicious = false
+	defer xp.signReply(reply)
+
 	xp.mu.Lock()
 	msgDigest := digest(prepareEntry.Request)
 	signature := xp.sign(msgDigest)
 	reply.MsgDigest = msgDigest
-	reply.Signature = signature
+	reply.SeqNum = prepareEntry.Msg0.PrepareSeqNum
 
-	if xp.view != prepareEntry.Msg0.View {
+	if xp.view != prepareEntry.Msg0.View || xp.vcInProgress == true {
 		xp.mu.Unlock()
 		return
 	}
 
+	if bytes.Compare(prepareEntry.Msg0.MsgDigest[:], msgDigest[:]) == 0 {
+		xp.checkPrepare(prepareEntry.Msg0) // The leader may have prepared another request for this sequence number
+	}
+
 	if prepareEntry.Msg0.PrepareSeqNum == xp.prepareSeqNum+1 && bytes.Compare(prepareEntry.Msg0.MsgDigest[:],
-		msgDigest[:]) == 0 && xp.verify(prepareEntry.Msg0.SenderId, msgDigest, prepareEntry.Msg0.Signature) == true {
-		if len(xp.prepareLog) > 0 && prepareEntry.Request.Timestamp <= xp.prepareLog[len(xp.prepareLog)-1].Msg0.ClientTimestamp {
+		msgDigest[:]) == 0 && xp.verify(prepareEntry.Msg0.SenderId, prepareDigest(prepareEntry.Msg0),
+		prepareEntry.Msg0.Signature) == true {
+		if xp.validRequest(prepareEntry.Request) == false { // Leader prepared a faulty client's request
+			reply.Suspicious = true
+			go xp.issueSuspect(xp.view)
+			xp.mu.Unlock()
+			return
+		}
+
+		if prepareEntry.Request.Timestamp <= xp.getClientTimestamp(prepareEntry.Request.ClientId) {
 			reply.Success = true
 			xp.mu.Unlock()
 			return
@@ -163,44 +227,63 @@ func (xp *XPaxos) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 
 		xp.prepareSeqNum++
 		xp.prepareLog = append(xp.prepareLog, prepareEntry)
+		xp.clientTimestamps[prepareEntry.Request.ClientId] = prepareEntry.Request.Timestamp
 
 		msg := Message{
-			MsgType:         COMMIT,
-			MsgDigest:       msgDigest,
-			Signature:       signature,
-			PrepareSeqNum:   xp.prepareSeqNum,
-			View:            xp.view,
-			ClientTimestamp: prepareEntry.Request.Timestamp,
-			SenderId:        xp.id}
+			MsgType:          COMMIT,
+			MsgDigest:        msgDigest,
+			Signature:        signature,
+			PrepareSeqNum:    xp.prepareSeqNum,
+			View:             xp.view,
+			ClientTimestamp:  prepareEntry.Request.Timestamp,
+			SenderId:         xp.id,
+			PrepareSignature: prepareEntry.Msg0.Signature}
 
 		if xp.executeSeqNum >= len(xp.commitLog) {
 			msgMap := make(map[int]Message, 0)
 			msgMap[xp.id] = msg                                                   // Follower's commit message
 			xp.appendToCommitLog(prepareEntry.Request, prepareEntry.Msg0, msgMap) // Leader's prepare message is prepareEntry.Msg0
+			xp.checkCommitted(len(xp.commitLog) - 1)
 		}
 
 		numReplies := len(xp.synchronousGroup) - 1
-		replyCh := make(chan bool, numReplies)
+		b := makeBroadcast(numReplies)
 
 		for server, _ := range xp.synchronousGroup {
 			if server != xp.id {
-				go xp.issueCommit(server, msg, replyCh)
+				b.add(server, xp.sendCommit(server, msg, b.done))
 			}
 		}
 		xp.mu.Unlock()
 
-		timer := time.NewTimer(3 * network.DELTA * time.Millisecond).C
+		timer := xp.clock.After(3 * network.DELTA * time.Millisecond)
+		retry := make(chan int, numReplies) // At most one retransmission pending per server
 
-		for i := 0; i < numReplies; i++ {
+		for received := 0; received < numReplies; {
 			select {
 			case <-timer:
 				dPrintf("Timeout: XPaxos.Prepare: XPaxos server (%d)\n", xp.id)
+				go xp.issueSuspect(msg.View)
 				return
-			case <-replyCh:
+			case call := <-b.done:
+				server := b.calls[call]
+				committed, retransmit := xp.handleCommitReply(server, call, msg)
+				if committed == true {
+					received++
+				} else if retransmit == true {
+					// Retransmit if commit RPC fails - DO NOT CHANGE; wait on the clock first, so that a
+					// simulated network lets time pass between retransmissions (see network/clock.go)
+					go func(server int) {
+						xp.clock.Sleep(10 * time.Millisecond)
+						retry <- server
+					}(server)
+				}
+			case server := <-retry:
+				b.add(server, xp.sendCommit(server, msg, b.done))
 			}
 		}
 
-		timer = time.NewTimer(3 * network.DELTA * time.Millisecond).C
+		timer = xp.clock.After(3 * network.DELTA * time.Millisecond)
 
 		// Busy wait until XPaxos server receives commit messages from entire synchronous group
 		xp.mu.Lock()
@@ -209,9 +292,10 @@ func (xp *XPaxos) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 			select {
 			case <-timer:
 				dPrintf("Timeout: XPaxos.Prepare: XPaxos server (%d)\n", xp.id)
+				go xp.issueSuspect(msg.View)
 				return
 			default:
-				time.Sleep(10 * time.Millisecond)
+				xp.clock.Sleep(10 * time.Millisecond)
 			}
 			xp.mu.Lock()
 		}
@@ -222,6 +306,7 @@ func (xp *XPaxos) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 		}
 
 		xp.executeSeqNum++
+		xp.execute()
 		reply.Success = true
 	} else { // Verification of crypto signature in prepareEntry fails
 		reply.Suspicious = true
@@ -233,67 +318,75 @@ func (xp *XPaxos) Prepare(prepareEntry PrepareLogEntry, reply *Reply) {
 //
 // --------------------------------- COMMIT RPC --------------------------------
 //
-func (xp *XPaxos) sendCommit(server int, msg Message, reply *Reply) bool {
-	if xp.byzantine == true {
-		for i := len(msg.Signature) - 1; i > 0; i-- {
-			j := rand.Intn(i + 1)
-			msg.Signature[i], msg.Signature[j] = msg.Signature[j], msg.Signature[i]
-		}
-	}
-
+func (xp *XPaxos) sendCommit(server int, msg Message, done chan *network.Call) *network.Call {
 	dPrintf("Commit: from XPaxos server (%d) to XPaxos server (%d)\n", xp.id, server)
-	return xp.replicas[server].Call("XPaxos.Commit", msg, reply, xp.id)
+	return xp.replicas[server].Go("XPaxos.Commit", msg, &Reply{}, xp.id, done)
 }
 
-func (xp *XPaxos) issueCommit(server int, msg Message, replyCh chan bool) {
-	reply := &Reply{}
+// Reports whether server accepted the commit, or else whether the commit should be sent again
+func (xp *XPaxos) handleCommitReply(server int, call *network.Call, msg Message) (committed bool, retransmit bool) {
+	reply := call.Reply.(*Reply)
 
-	if ok := xp.sendCommit(server, msg, reply); ok {
-		xp.mu.Lock()
-		if xp.view != msg.View {
-			xp.mu.Unlock()
-			return
-		}
+	if call.Ok == false { // RPC times out after time frame delta (see network)
+		go xp.issueSuspect(msg.View)
+		return false, false
+	}
 
-		verification := xp.verify(server, reply.MsgDigest, reply.Signature)
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
 
-		if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) == 0 && verification == true {
-			if reply.Success == true {
-				replyCh <- reply.Success
-			} else if reply.Suspicious == true {
-				xp.mu.Unlock()
-				return
-			} else {
-				go xp.issueCommit(server, msg, replyCh) // Retransmit if commit RPC fails - DO NOT CHANGE
-			}
-		} else { // Verification of crypto signature in reply fails
-			go xp.issueSuspect(xp.view)
-		}
-		xp.mu.Unlock()
-	} else { // RPC times out after time frame delta (see network)
-		go xp.issueSuspect(msg.View)
+	if xp.view != msg.View {
+		return false, false
+	}
+
+	verification := xp.verifyReply(server, reply)
+
+	if bytes.Compare(msg.MsgDigest[:], reply.MsgDigest[:]) == 0 && reply.SeqNum == msg.PrepareSeqNum &&
+		verification == true {
+		return reply.Success, reply.Success == false && reply.Suspicious == false
 	}
+	go xp.issueSuspect(xp.view) // Verification of crypto signature in reply fails
+	return false, false
 }
 
 func (xp *XPaxos) Commit(msg Message, reply *Reply) {
 	// By default reply.Success == false
+	defer xp.signReply(reply)
+
 	xp.mu.Lock()
 	defer xp.mu.Unlock()
 
 	msgDigest := msg.MsgDigest
-	signature := xp.sign(msgDigest)
 	reply.MsgDigest = msgDigest
-	reply.Signature = signature
+	reply.SeqNum = msg.PrepareSeqNum
 
 	if xp.view != msg.View {
 		reply.Suspicious = true
 		return
 	}
 
-	if xp.verify(msg.SenderId, msgDigest, msg.Signature) == true {
-		if xp.executeSeqNum < len(xp.commitLog) {
+	prepare := xp.committedPrepare(msg)
+
+	if xp.verify(msg.SenderId, msgDigest, msg.Signature) == true &&
+		xp.verify(prepare.SenderId, prepareDigest(prepare), prepare.Signature) == true {
+		seqNum := msg.PrepareSeqNum - 1
+		if seqNum >= 0 && seqNum < len(xp.prepareLog) &&
+			bytes.Compare(msgDigest[:], xp.prepareLog[seqNum].Msg0.MsgDigest[:]) != 0 {
+			// The sender committed a different request for this sequence number, so the leader
+			// must have sent different prepares to different members of the synchronous group
+			xp.checkPrepare(prepare)
+			reply.Suspicious = true
+			go xp.issueSuspect(xp.view)
+			return
+		}
+
+		// File the commit under the sequence number it commits, as part of that request's commit
+		// certificate (see certificate.go)
+		if seqNum >= 0 && seqNum < len(xp.commitLog) && xp.commitLog[seqNum].View == msg.View &&
+			xp.synchronousGroup[msg.SenderId] == true {
 			senderId := msg.SenderId
-			xp.commitLog[xp.executeSeqNum].Msg1[senderId] = msg
+			xp.commitLog[seqNum].Msg1[senderId] = msg
+			xp.checkCommitted(seqNum)
 			reply.Success = true
 		}
 	} else { // Verification of crypto signature in msg fails
@@ -327,7 +420,7 @@ func (xp *XPaxos) Ping(view int, reply *Reply) {
 //
 // ------------------------------- MAKE FUNCTION ------------------------------
 //
-func Make(replicas []*network.ClientEnd, id int, privateKey *rsa.PrivateKey,
+func Make(replicas []network.Transport, id int, privateKey *rsa.PrivateKey,
 	publicKeys map[int]*rsa.PublicKey) *XPaxos {
 	xp := &XPaxos{}
 
@@ -350,7 +443,18 @@ func Make(replicas []*network.ClientEnd, id int, privateKey *rsa.PrivateKey,
 	xp.vcTimer = nil
 	xp.receivedVCFinal = make(map[int]map[[32]byte]ViewChangeMessage, 0)
 	xp.vcInProgress = false
-	xp.byzantine = false
+	xp.clientTimestamps = make(map[int]int, 0)
+	xp.stateMachine = nil
+	xp.applySeqNum = 0
+	xp.clientResults = make(map[int]clientResult, 0)
+	xp.monitor = nil
+	xp.faultReports = make([]FaultReport, 0)
+	xp.proofs = make([]MisbehaviourProof, 0)
+	xp.readmitted = make(map[int]int, 0)
+	xp.readmitNonces = make(map[int]int, 0)
+	xp.adminKey = nil
+	xp.pending = make([]ClientRequest, 0)
+	xp.clock = network.RealClock
 
 	xp.generateSynchronousGroup(int64(xp.view))
 	xp.mu.Unlock()
@@ -358,4 +462,36 @@ func Make(replicas []*network.ClientEnd, id int, privateKey *rsa.PrivateKey,
 	return xp
 }
 
+// Run the XPaxos server's timers on clock (i.e. a simulated network's virtual clock)
+func (xp *XPaxos) SetClock(clock network.Clock) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	xp.clock = clock
+}
+
+// Execute committed requests against sm (i.e. MakeKVStore()); must be set before any request
+func (xp *XPaxos) SetStateMachine(sm StateMachine) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	xp.stateMachine = sm
+}
+
+// Execute re-admissions signed with the operator's key (see misbehaviour.go); without it, none are
+func (xp *XPaxos) SetAdminKey(adminKey *rsa.PublicKey) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	xp.adminKey = adminKey
+}
+
+// Report committed and executed requests and new views to m (see monitor.go)
+func (xp *XPaxos) SetMonitor(m *Monitor) {
+	xp.mu.Lock()
+	defer xp.mu.Unlock()
+
+	xp.monitor = m
+}
+
 func (xp *XPaxos) Kill() {}